@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+	"wisdomizer/models"
+	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/uploads"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// extractTimeout bounds the text extraction of a document, a document taking longer is stored without text
+const extractTimeout = 30 * time.Second
+
+// uploadError is an upload refused for its content, size or type
+type uploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+func Files(r *gin.Engine) {
+	r.GET("/chat/:uuid/files", handleListFiles)
+	r.POST("/chat/:uuid/files", handleUploadFiles)
+	r.GET("/files/:uuid", handleDownloadFile)
+	r.DELETE("/files/:uuid", handleDeleteFile)
+}
+
+func handleListFiles(c *gin.Context) {
+	store := models.Default()
+	chat, err := store.Chats.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get chat", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return
+	}
+
+	files, err := store.Files.GetByChatID(chat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get files", zap.Error(err), zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get files"})
+		return
+	}
+
+	if files == nil {
+		files = []models.File{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"files": files})
+}
+
+// handleDownloadFile streams the original upload as an attachment with its sniffed type
+func handleDownloadFile(c *gin.Context) {
+	file, err := models.Default().Files.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get file", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	content, err := os.Open(file.Path)
+	if err != nil {
+		logs.Logger.Error("Failed to open file",
+			zap.Error(err),
+			zap.String("file_uuid", file.UUID),
+			zap.String("path", file.Path))
+		c.JSON(http.StatusNotFound, gin.H{"error": "File content not found"})
+		return
+	}
+	defer content.Close()
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// The browser must not guess another type, such as HTML from an uploaded text file
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	// ServeContent answers range and conditional requests
+	http.ServeContent(c.Writer, c.Request, "", file.CreatedAt, content)
+}
+
+func handleDeleteFile(c *gin.Context) {
+	store := models.Default()
+	file, err := store.Files.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get file", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	if err := store.Files.Delete(file.UUID); err != nil {
+		logs.Logger.Error("Failed to delete file", zap.Error(err), zap.String("file_uuid", file.UUID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		return
+	}
+
+	// The row is gone, a blob left behind is only logged
+	if err := uploads.Remove(file.Path); err != nil {
+		logs.Logger.Warn("Failed to remove file", zap.Error(err), zap.String("path", file.Path))
+	}
+
+	logs.Logger.Info("Deleted file",
+		zap.String("file_uuid", file.UUID),
+		zap.Int("chat_id", file.ChatID))
+
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+}
+
+// handleUploadFiles stores the files of a multipart request part by part, without holding them in memory.
+// The files wait for a POST /chat referencing their UUIDs in file_uuids.
+func handleUploadFiles(c *gin.Context) {
+	store := models.Default()
+	chat, err := store.Chats.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get chat", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request must be multipart/form-data"})
+		return
+	}
+
+	used, err := store.Files.GetTotalSize(chat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get chat upload usage", zap.Error(err), zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store files"})
+		return
+	}
+
+	// The request is stored as a whole, a refused file discards the ones before it
+	limits := uploads.LimitsFromEnv()
+	var files []*models.File
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			discardFiles(files)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart body"})
+			return
+		}
+
+		// Only file parts are stored, other form fields are ignored
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		file, err := storeUpload(c.Request.Context(), chat, part.FileName(), part, limits, used)
+		part.Close()
+		if err != nil {
+			discardFiles(files)
+			respondUploadError(c, chat, err)
+			return
+		}
+
+		used += file.Size
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files uploaded"})
+		return
+	}
+
+	err = store.Transaction(func(tx *models.Store) error {
+		for _, file := range files {
+			if err := tx.Files.Create(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		discardFiles(files)
+		logs.Logger.Error("Failed to save files", zap.Error(err), zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store files"})
+		return
+	}
+
+	logs.Logger.Info("Stored uploaded files",
+		zap.Int("file_count", len(files)),
+		zap.Int("chat_id", chat.ID))
+
+	c.JSON(http.StatusCreated, gin.H{"files": files})
+}
+
+// storeUpload sniffs the type of content and streams it to the uploads directory within limits, used
+// is the number of bytes the chat already stores. It returns the row of the file without creating it.
+func storeUpload(ctx context.Context, chat *models.Chat, name string, content io.Reader, limits uploads.Limits, used int64) (*models.File, error) {
+	head := make([]byte, uploads.SniffSize)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	head = head[:n]
+
+	contentType := uploads.DetectType(name, head)
+	if !limits.Allows(contentType) {
+		return nil, &uploadError{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("%s: file type %s is not allowed", name, contentType),
+		}
+	}
+
+	limit := limits.MaxFileSize
+	tooLarge := fmt.Sprintf("%s: file exceeds the limit of %d bytes", name, limits.MaxFileSize)
+	if remaining := limits.MaxChatSize - used; remaining < limit {
+		limit = max(remaining, 0)
+		tooLarge = fmt.Sprintf("%s: topic exceeds its upload quota of %d bytes", name, limits.MaxChatSize)
+	}
+	if int64(len(head)) > limit {
+		return nil, &uploadError{Status: http.StatusRequestEntityTooLarge, Message: tooLarge}
+	}
+
+	// Reading one byte past the limit tells an exact fit from a file that is too large
+	fileUUID := uuid.New().String()
+	path, size, err := uploads.Save(fileUUID, io.LimitReader(io.MultiReader(bytes.NewReader(head), content), limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > limit {
+		if err := uploads.Remove(path); err != nil {
+			logs.Logger.Warn("Failed to remove file", zap.Error(err), zap.String("path", path))
+		}
+		return nil, &uploadError{Status: http.StatusRequestEntityTooLarge, Message: tooLarge}
+	}
+
+	return &models.File{
+		UUID:        fileUUID,
+		ChatID:      chat.ID,
+		Name:        name,
+		Path:        path,
+		ContentType: contentType,
+		Size:        size,
+		Text:        fileText(ctx, name, path, contentType),
+	}, nil
+}
+
+// fileText returns the text of an upload for search, extracting it from documents caches it for the
+// prompt. A file that cannot be read is still stored.
+func fileText(ctx context.Context, name string, path string, contentType string) string {
+	ctx, cancel := context.WithTimeout(ctx, extractTimeout)
+	defer cancel()
+
+	var text string
+	var err error
+	switch {
+	case uploads.IsText(contentType):
+		text, err = uploads.ReadText(path)
+	case uploads.CanExtract(contentType):
+		text, err = uploads.ExtractText(ctx, path, contentType)
+	default:
+		return ""
+	}
+
+	if err != nil {
+		logs.Logger.Warn("Failed to read file text",
+			zap.Error(err),
+			zap.String("name", name))
+		return ""
+	}
+
+	return text
+}
+
+// saveUpload stores an attachment sent inline with a chat request, it is created with the message
+func saveUpload(ctx context.Context, chat *models.Chat, upload *File) (*models.File, error) {
+	content, err := base64.StdEncoding.DecodeString(upload.Content)
+	if err != nil {
+		return nil, &uploadError{Status: http.StatusBadRequest, Message: "File content must be base64 encoded"}
+	}
+
+	used, err := models.Default().Files.GetTotalSize(chat.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return storeUpload(ctx, chat, upload.Name, bytes.NewReader(content), uploads.LimitsFromEnv(), used)
+}
+
+// copyFiles copies the files a message carried for the message replacing it, such as an edit. The rows
+// are returned without being created, messageID is left for the caller to set.
+func copyFiles(ctx context.Context, chat *models.Chat, messageID int) ([]*models.File, error) {
+	files, err := models.Default().Files.GetByChatID(chat.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var copies []*models.File
+	for _, file := range files {
+		if file.MessageID != messageID {
+			continue
+		}
+
+		fileUUID := uuid.New().String()
+		path, err := uploads.Copy(file.Path, fileUUID)
+		if err != nil {
+			discardFiles(copies)
+			return nil, err
+		}
+
+		copies = append(copies, &models.File{
+			UUID:        fileUUID,
+			ChatID:      chat.ID,
+			Name:        file.Name,
+			Path:        path,
+			ContentType: file.ContentType,
+			Size:        file.Size,
+			Text:        fileText(ctx, file.Name, path, file.ContentType),
+		})
+	}
+
+	return copies, nil
+}
+
+// discardFiles removes the stored content of files whose rows were not created
+func discardFiles(files []*models.File) {
+	for _, file := range files {
+		if err := uploads.Remove(file.Path); err != nil {
+			logs.Logger.Warn("Failed to remove file", zap.Error(err), zap.String("path", file.Path))
+		}
+	}
+}
+
+// respondUploadError writes the response of a failed upload
+func respondUploadError(c *gin.Context, chat *models.Chat, err error) {
+	var refused *uploadError
+	if errors.As(err, &refused) {
+		c.JSON(refused.Status, gin.H{"error": refused.Message})
+		return
+	}
+
+	logs.Logger.Error("Failed to store file",
+		zap.Error(err),
+		zap.Int("chat_id", chat.ID))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store file"})
+}
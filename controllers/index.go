@@ -1,12 +1,20 @@
 package controllers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"strconv"
 	"wisdomizer/models"
+	"wisdomizer/pkg/jobs"
+	"wisdomizer/pkg/llm"
 	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/stream"
+	"wisdomizer/pkg/tools"
+	"wisdomizer/pkg/uploads"
 	"wisdomizer/pkg/validation"
-	"wisdomizer/pkg/vendors/anthropic"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -14,29 +22,23 @@ import (
 )
 
 type ChatRequest struct {
-	Message  string `json:"message" binding:"required"`
-	Topic    string `json:"topic" binding:"required"`
-	File     *File  `json:"file,omitempty"`
-	ChatUUID string `json:"chat_uuid" binding:"required"`
-	System   string `json:"system,omitempty"`
+	Message   string   `json:"message" binding:"required"`
+	Topic     string   `json:"topic" binding:"required"`
+	File      *File    `json:"file,omitempty"`                                     // inline attachment
+	FileUUIDs []string `json:"file_uuids,omitempty" binding:"omitempty,dive,uuid"` // files uploaded with POST /chat/:uuid/files
+	ChatUUID  string   `json:"chat_uuid" binding:"required"`
 }
 
 type File struct {
 	Name    string `json:"name"`
-	Content string `json:"content"`
+	Content string `json:"content"` // base64 encoded
 	Type    string `json:"type"`
 }
 
-type ChatResponse struct {
-	Message string `json:"message"`
-	Topic   string `json:"topic"`
-}
-
 func Index(r *gin.Engine) {
 	r.GET("/", func(c *gin.Context) {
 		// Get all chats
-		chat := &models.Chat{}
-		chats, err := chat.GetAll()
+		chats, err := models.Default().Chats.GetAll()
 		if err != nil {
 			logs.Logger.Error("Failed to get chats", zap.Error(err))
 			c.HTML(http.StatusOK, "index", gin.H{
@@ -54,6 +56,8 @@ func Index(r *gin.Engine) {
 
 	r.POST("/chat", validation.Validate[ChatRequest](), handleChat)
 	r.GET("/chat/:uuid", handleGetChatHistory)
+	r.POST("/chat/:uuid/cancel", handleCancelChat)
+	r.GET("/chat/:uuid/stream", handleStreamChat)
 }
 
 func handleChat(c *gin.Context) {
@@ -72,8 +76,8 @@ func handleChat(c *gin.Context) {
 		zap.Int("message_length", len(req.Message)))
 
 	// Get existing chat
-	chat := &models.Chat{}
-	chat, err := chat.GetByUUID(req.ChatUUID)
+	store := models.Default()
+	chat, err := store.Chats.GetByUUID(req.ChatUUID)
 	if err != nil {
 		logs.Logger.Error("Failed to get chat",
 			zap.Error(err),
@@ -86,15 +90,77 @@ func handleChat(c *gin.Context) {
 		zap.Int("chat_id", chat.ID),
 		zap.String("chat_title", chat.Title))
 
-	// Save user message
+	job, chat, ok := reserveChat(c, chat)
+	if !ok {
+		return
+	}
+	defer job.Discard()
+
+	// Uploaded files must belong to the chat and not be sent yet
+	var uploaded []*models.File
+	for _, fileUUID := range req.FileUUIDs {
+		file, err := store.Files.GetByUUID(fileUUID)
+		if err != nil || file.ChatID != chat.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File not found: " + fileUUID})
+			return
+		}
+		if file.MessageID != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File already sent: " + fileUUID})
+			return
+		}
+		uploaded = append(uploaded, file)
+	}
+
+	// Store the inline attachment before the turn, the row is saved with the message
+	var file *models.File
+	if req.File != nil {
+		file, err = saveUpload(c.Request.Context(), chat, req.File)
+		if err != nil {
+			respondUploadError(c, chat, err)
+			return
+		}
+	}
+
+	// Save user message as the next turn of the active branch
 	message := &models.Message{
-		UUID:    uuid.New().String(),
-		ChatID:  chat.ID,
-		Role:    "user",
-		Content: req.Message,
+		UUID:     uuid.New().String(),
+		ChatID:   chat.ID,
+		ParentID: chat.ActiveMessageID,
+		Role:     "user",
+		Content:  req.Message,
 	}
 
-	if err := message.Create(*message); err != nil {
+	// The turn is saved as a whole, a failure leaves no user message without its reply
+	var aiMessage *models.Message
+	err = store.Transaction(func(tx *models.Store) error {
+		// The system prompt of the topic is changed through PUT /topics/:uuid only
+		message.SystemPromptVersion = chat.SystemPromptVersion
+		if err := tx.Messages.Create(message); err != nil {
+			return err
+		}
+
+		// Link the files to the message that carried them
+		for _, uploadedFile := range uploaded {
+			if err := tx.Files.AttachToMessage(uploadedFile.ID, message.ID); err != nil {
+				return err
+			}
+		}
+		if file != nil {
+			file.MessageID = message.ID
+			if err := tx.Files.Create(file); err != nil {
+				return err
+			}
+		}
+
+		aiMessage = newReply(chat, job, message)
+		return tx.Messages.Create(aiMessage)
+	})
+	if err != nil {
+		if file != nil {
+			if removeErr := uploads.Remove(file.Path); removeErr != nil {
+				logs.Logger.Warn("Failed to remove file", zap.Error(removeErr), zap.String("path", file.Path))
+			}
+		}
 		logs.Logger.Error("Failed to save user message",
 			zap.Error(err),
 			zap.Int("chat_id", chat.ID))
@@ -104,38 +170,70 @@ func handleChat(c *gin.Context) {
 
 	logs.Logger.Info("Saved user message",
 		zap.String("message_uuid", message.UUID),
+		zap.Int("system_prompt_version", chat.SystemPromptVersion),
 		zap.Int("chat_id", chat.ID))
 
-	// Handle file if present
-	if req.File != nil {
-		file := &models.File{
-			UUID:        uuid.New().String(),
-			ChatID:      chat.ID,
-			Name:        req.File.Name,
-			Path:        "", // TODO: Save file to disk and store path
-			ContentType: req.File.Type,
-			Size:        int64(len(req.File.Content)),
-		}
+	generateReply(c, chat, job, message, aiMessage)
+}
 
-		if err := file.Create(*file); err != nil {
-			logs.Logger.Error("Failed to save file",
-				zap.Error(err),
-				zap.Int("chat_id", chat.ID))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-			return
-		}
+// newReply returns the pending assistant message job generates in reply to message, it is created
+// upfront so tool calls can reference it
+func newReply(chat *models.Chat, job *jobs.Job, message *models.Message) *models.Message {
+	return &models.Message{
+		UUID:                job.MessageUUID,
+		ChatID:              chat.ID,
+		ParentID:            message.ID,
+		Role:                "assistant",
+		SystemPromptVersion: chat.SystemPromptVersion,
+		Status:              models.MessagePending,
+	}
+}
+
+// reserveChat creates the generation job holding the chat, so concurrent turns cannot interleave,
+// and returns the chat read again under it, writing the error response on failure. The generation
+// runs as a job, it continues when the client disconnects and stops when POST /chat/:uuid/cancel is called.
+func reserveChat(c *gin.Context, chat *models.Chat) (*jobs.Job, *models.Chat, bool) {
+	job, err := jobs.New(chat.UUID, uuid.New().String())
+	if busy, ok := err.(*jobs.BusyError); ok {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":        "A reply is already being generated for this chat",
+			"job_id":       busy.Job.ID,
+			"message_uuid": busy.Job.MessageUUID,
+		})
+		return nil, nil, false
+	}
+	if err != nil {
+		logs.Logger.Error("Failed to create generation job",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create generation job"})
+		return nil, nil, false
+	}
 
-		logs.Logger.Info("Saved file",
-			zap.String("file_uuid", file.UUID),
-			zap.String("file_name", file.Name))
+	// A turn that finished before the reservation may have moved the active message
+	reserved, err := models.Default().Chats.GetByUUID(chat.UUID)
+	if err != nil {
+		job.Discard()
+		logs.Logger.Error("Failed to get chat",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat"})
+		return nil, nil, false
 	}
 
+	return job, reserved, true
+}
+
+// generateReply starts job answering the user message at the end of the active branch into aiMessage,
+// created with newReply, and relays its events to the client
+func generateReply(c *gin.Context, chat *models.Chat, job *jobs.Job, message *models.Message, aiMessage *models.Message) {
 	// Get chat history
-	messages, err := chat.GetMessagesByChatID(chat.ID)
+	messages, err := models.Default().Messages.GetActivePath(chat.ID)
 	if err != nil {
 		logs.Logger.Error("Failed to get chat history",
 			zap.Error(err),
 			zap.Int("chat_id", chat.ID))
+		abandonReply(chat, aiMessage)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat history"})
 		return
 	}
@@ -144,106 +242,372 @@ func handleChat(c *gin.Context) {
 		zap.Int("message_count", len(messages)),
 		zap.Int("chat_id", chat.ID))
 
-	// Convert messages to Anthropic format
-	var anthropicMessages []anthropic.Message
+	provider, err := llm.Get(chat.Provider)
+	if err != nil {
+		logs.Logger.Error("Failed to get LLM provider",
+			zap.Error(err),
+			zap.String("provider", chat.Provider),
+			zap.Int("chat_id", chat.ID))
+		abandonReply(chat, aiMessage)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get LLM provider"})
+		return
+	}
+
+	if err := attachFiles(chat.ID, messages); err != nil {
+		logs.Logger.Error("Failed to get chat files",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		abandonReply(chat, aiMessage)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat files"})
+		return
+	}
+
+	// Convert messages to provider-agnostic format
+	var llmMessages []llm.Message
 	for _, msg := range messages {
-		anthropicMessages = append(anthropicMessages, anthropic.Message{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
+		// Skip assistant placeholders of failed generations
+		if msg.Content == "" {
+			continue
+		}
+
+		// Roles must alternate, the turn after a skipped reply is merged into the one before it
+		prompt := promptMessage(c.Request.Context(), msg, provider)
+		if last := len(llmMessages) - 1; last >= 0 && llmMessages[last].Role == prompt.Role {
+			llmMessages[last].Content = append(llmMessages[last].Content, prompt.Content...)
+			continue
+		}
+		llmMessages = append(llmMessages, prompt)
 	}
 
-	// Prepare options for Anthropic API
-	opts := anthropic.Option{
-		Messages:    anthropicMessages,
-		Stream:      true,
-		System:      "You are a helpful AI assistant.",
-		MaxTokens:   4096,
-		Temperature: 0.7,
+	// Send exactly the tools enabled for this chat
+	chatTools, toolHandler, err := tools.ForChat(chat.ID, aiMessage.ID, func(toolCall models.ToolCall) {
+		// Ask the user, the agent loop resumes once the tool call is approved or denied
+		publishEvent(job, chat.ID, stream.EventApprovalRequired, stream.ApprovalRequired{ToolCall: toolCall})
+	})
+	if err != nil {
+		logs.Logger.Error("Failed to get chat tools",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		abandonReply(chat, aiMessage)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat tools"})
+		return
 	}
 
-	// Use custom system prompt if provided
-	if req.System != "" {
-		opts.System = req.System
+	// Prepare LLM request
+	opts := llm.Request{
+		Model:       chat.Model,
+		Messages:    llmMessages,
+		Stream:      true,
+		System:      chat.SystemPrompt,
+		MaxTokens:   chat.MaxTokens,
+		Temperature: &chat.Temperature,
+		Tools:       chatTools,
+		ToolHandler: toolHandler,
 	}
 
-	// Set up streaming response
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Transfer-Encoding", "chunked")
+	if opts.System == "" {
+		opts.System = llm.DefaultSystem
+	}
 
 	// Define callback for streaming chunks
-	opts.Callback = func(chunk string) {
+	opts.Callback = func(delta llm.Delta) {
 		// Log the raw chunk for debugging
-		logs.Logger.Debug("Received chunk from Anthropic",
-			zap.String("chunk", chunk),
+		logs.Logger.Debug("Received chunk from provider",
+			zap.String("provider", provider.Name()),
+			zap.String("type", delta.Type),
+			zap.String("chunk", delta.Text),
 			zap.Int("chat_id", chat.ID))
 
-		// Create a JSON response with the chunk
-		response := map[string]interface{}{
-			"content": chunk,
+		if event, data, ok := stream.FromDelta(delta); ok {
+			publishEvent(job, chat.ID, event, data)
 		}
+	}
 
-		// Marshal the response
-		jsonData, err := json.Marshal(response)
-		if err != nil {
-			logs.Logger.Error("Failed to marshal chunk",
-				zap.Error(err),
+	publishEvent(job, chat.ID, stream.EventMessageStart, stream.MessageStart{
+		JobID:                job.ID,
+		ChatUUID:             chat.UUID,
+		UserMessageUUID:      message.UUID,
+		AssistantMessageUUID: aiMessage.UUID,
+		Provider:             provider.Name(),
+		Model:                chat.Model,
+	})
+
+	job.Start(func(ctx context.Context) {
+		// Run the agent loop against the provider
+		response, runErr := llm.Run(ctx, provider, opts, llm.LimitsFromEnv())
+		aiMessage.Status = models.MessageCompleted
+		switch {
+		case errors.Is(runErr, context.Canceled):
+			aiMessage.Status = models.MessageStopped
+			logs.Logger.Info("Generation stopped",
+				zap.String("message_uuid", aiMessage.UUID),
+				zap.Int("chat_id", chat.ID),
+				zap.Int("response_length", len(response.Content)))
+		case runErr != nil:
+			aiMessage.Status = models.MessageFailed
+			logs.Logger.Error("Failed to get response from provider",
+				zap.Error(runErr),
+				zap.String("provider", provider.Name()),
+				zap.String("model", opts.Model),
+				zap.Int("message_count", len(opts.Messages)),
+				zap.Strings("tools", toolNames(opts.Tools)),
 				zap.Int("chat_id", chat.ID))
-			return
+			publishEvent(job, chat.ID, stream.EventError, stream.Error{Message: "Failed to get response from provider"})
+		default:
+			logs.Logger.Info("Received response from provider",
+				zap.String("provider", provider.Name()),
+				zap.Int("chat_id", chat.ID),
+				zap.Int("steps", len(response.Steps)),
+				zap.String("stop_reason", response.StopReason),
+				zap.Int("input_tokens", response.Usage.InputTokens),
+				zap.Int("output_tokens", response.Usage.OutputTokens),
+				zap.Int("response_length", len(response.Content)))
 		}
 
-		// Write the chunk as a server-sent event
-		_, err = c.Writer.Write([]byte("data: " + string(jsonData) + "\n\n"))
-		if err != nil {
-			logs.Logger.Error("Failed to write chunk",
+		// Save AI response, including the text streamed before a failure, whether or not anyone listens
+		aiMessage.Content = response.Content
+		if err := models.Default().Messages.Update(aiMessage); err != nil {
+			logs.Logger.Error("Failed to save AI response",
 				zap.Error(err),
 				zap.Int("chat_id", chat.ID))
+			publishEvent(job, chat.ID, stream.EventError, stream.Error{Message: "Failed to save AI response"})
+		} else {
+			logs.Logger.Info("Saved AI response",
+				zap.String("message_uuid", aiMessage.UUID),
+				zap.Int("chat_id", chat.ID))
 		}
-		c.Writer.Flush()
+
+		publishEvent(job, chat.ID, stream.EventDone, stream.Done{
+			AssistantMessageUUID: aiMessage.UUID,
+			Status:               aiMessage.Status,
+			StopReason:           response.StopReason,
+			Usage:                response.Usage,
+			Topic:                chat.Title,
+		})
+	})
+
+	logs.Logger.Info("Started generation",
+		zap.String("job_id", job.ID),
+		zap.String("message_uuid", aiMessage.UUID),
+		zap.Int("chat_id", chat.ID))
+
+	relayJob(c, job, 0)
+}
+
+// toolNames returns the names of the tools of a request, its logs leave out the content sent
+func toolNames(tools []llm.Tool) []string {
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool.Name
 	}
 
-	// Call Anthropic API
-	response, err := anthropic.Chat(opts)
-	if err != nil {
-		logs.Logger.Error("Failed to get response from Anthropic",
+	return names
+}
+
+// abandonReply fails aiMessage when its generation cannot start, so it is not left pending
+func abandonReply(chat *models.Chat, aiMessage *models.Message) {
+	aiMessage.Status = models.MessageFailed
+	if err := models.Default().Messages.Update(aiMessage); err != nil {
+		logs.Logger.Error("Failed to fail AI response",
 			zap.Error(err),
-			zap.Any("messages", anthropicMessages),
-			zap.Any("opts", opts),
+			zap.String("message_uuid", aiMessage.UUID),
 			zap.Int("chat_id", chat.ID))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get response from Anthropic"})
-		return
 	}
+}
 
-	logs.Logger.Info("Received response from Anthropic",
-		zap.Int("chat_id", chat.ID),
-		zap.Int("response_length", len(response.Content[0].Text)))
+// attachFiles sets the files of each message
+func attachFiles(chatID int, messages []models.Message) error {
+	files, err := models.Default().Files.GetByChatID(chatID)
+	if err != nil {
+		return err
+	}
+
+	byMessage := map[int][]models.File{}
+	for _, file := range files {
+		byMessage[file.MessageID] = append(byMessage[file.MessageID], file)
+	}
+	for i := range messages {
+		messages[i].Files = byMessage[messages[i].ID]
+	}
+
+	return nil
+}
+
+// promptMessage converts a message to the provider-agnostic format, the files it carries precede its
+// content. Text files and the text extracted from documents become document blocks, images become image
+// blocks when the provider accepts them, and PDFs native documents when the provider reads them.
+func promptMessage(ctx context.Context, msg models.Message, provider llm.Provider) llm.Message {
+	prompt := llm.Message{Role: msg.Role}
+	var attachments []llm.ContentBlock
+	for _, file := range msg.Files {
+		switch {
+		case uploads.IsPDF(file.ContentType) && readsPDF(provider, file):
+			data, err := os.ReadFile(file.Path)
+			if err != nil {
+				logs.Logger.Warn("Failed to read PDF for the prompt",
+					zap.Error(err),
+					zap.String("file_uuid", file.UUID))
+				continue
+			}
+			attachments = append(attachments, llm.ContentBlock{
+				Type:      llm.BlockDocument,
+				Name:      file.Name,
+				MediaType: uploads.TypePDF,
+				Data:      data,
+			})
+		case uploads.IsText(file.ContentType) || uploads.CanExtract(file.ContentType):
+			text, err := readDocument(ctx, file)
+			if err != nil {
+				logs.Logger.Warn("Failed to read file for the prompt",
+					zap.Error(err),
+					zap.String("file_uuid", file.UUID))
+				continue
+			}
+
+			prompt.Content = append(prompt.Content, llm.ContentBlock{
+				Type: llm.BlockText,
+				Text: uploads.Document(file.Name, file.ContentType, text),
+			})
+		case uploads.IsImage(file.ContentType):
+			limiter, ok := provider.(llm.ImageLimiter)
+			if !ok {
+				continue
+			}
+
+			block, err := imageBlock(file, limiter.ImageLimits())
+			if err != nil {
+				logs.Logger.Warn("Failed to read image for the prompt",
+					zap.Error(err),
+					zap.String("file_uuid", file.UUID))
+				continue
+			}
+			attachments = append(attachments, block)
+		}
+	}
 
-	// Save AI response
-	aiMessage := &models.Message{
-		UUID:    uuid.New().String(),
-		ChatID:  chat.ID,
-		Role:    "assistant",
-		Content: response.Content[0].Text,
+	prompt.Content = append(prompt.Content, attachments...)
+	prompt.Content = append(prompt.Content, llm.ContentBlock{Type: llm.BlockText, Text: msg.Content})
+	return prompt
+}
+
+// readDocument reads the text of a file for the prompt, extraction is bounded like at upload
+func readDocument(ctx context.Context, file models.File) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, extractTimeout)
+	defer cancel()
+
+	return uploads.ReadDocument(ctx, file.Path, file.ContentType)
+}
+
+// readsPDF reports whether the provider reads the PDF file natively
+func readsPDF(provider llm.Provider, file models.File) bool {
+	reader, ok := provider.(llm.DocumentReader)
+	if !ok {
+		return false
 	}
 
-	if err := aiMessage.Create(*aiMessage); err != nil {
-		logs.Logger.Error("Failed to save AI response",
+	limits, ok := reader.DocumentLimits()
+	if !ok || file.Size > limits.MaxBytes {
+		return false
+	}
+
+	pages, err := uploads.PDFPages(file.Path)
+	return err == nil && pages <= limits.MaxPages
+}
+
+// imageBlock reads an image file, scaled down to the limits of the provider
+func imageBlock(file models.File, limits llm.ImageLimits) (llm.ContentBlock, error) {
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return llm.ContentBlock{}, fmt.Errorf("failed to read image: %v", err)
+	}
+
+	data, mediaType, err := uploads.FitImage(data, limits.MaxEdge, limits.MaxBytes)
+	if err != nil {
+		return llm.ContentBlock{}, err
+	}
+
+	return llm.ContentBlock{Type: llm.BlockImage, MediaType: mediaType, Data: data}, nil
+}
+
+// publishEvent appends an event to the stream of a generation
+func publishEvent(job *jobs.Job, chatID int, event string, data any) {
+	if err := job.Publish(event, data); err != nil {
+		logs.Logger.Error("Failed to publish event",
 			zap.Error(err),
-			zap.Int("chat_id", chat.ID))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save AI response"})
+			zap.String("event", event),
+			zap.String("job_id", job.ID),
+			zap.Int("chat_id", chatID))
+	}
+}
+
+// relayJob streams the events of a job after the event ID after to the client until the job
+// finishes or the client goes away, the job itself keeps running
+func relayJob(c *gin.Context, job *jobs.Job, after int) {
+	events := stream.NewWriter(c.Writer)
+	err := job.Stream(c.Request.Context(), after, events.Send)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		logs.Logger.Warn("Stopped relaying generation",
+			zap.Error(err),
+			zap.String("job_id", job.ID))
+	}
+}
+
+func handleStreamChat(c *gin.Context) {
+	// EventSource clients resend the last ID they saw as Last-Event-ID
+	afterParam := c.Query("after")
+	if afterParam == "" {
+		afterParam = c.GetHeader("Last-Event-ID")
+	}
+
+	// Event IDs count within a job, they only resume the job they came from
+	jobID := c.Query("job_id")
+	after := 0
+	if afterParam != "" {
+		value, err := strconv.Atoi(afterParam)
+		if err != nil || value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after must be an event ID"})
+			return
+		}
+		if jobID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "job_id is required to resume after an event ID"})
+			return
+		}
+		after = value
+	}
+
+	job, ok := jobs.Latest(c.Param("uuid"))
+	if jobID != "" {
+		job, ok = jobs.Get(jobID)
+		ok = ok && job.ChatUUID == c.Param("uuid")
+	}
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No generation found for this chat"})
 		return
 	}
 
-	logs.Logger.Info("Saved AI response",
-		zap.String("message_uuid", aiMessage.UUID),
-		zap.Int("chat_id", chat.ID))
+	relayJob(c, job, after)
+}
+
+func handleCancelChat(c *gin.Context) {
+	job, ok := jobs.Running(c.Param("uuid"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No generation is running for this chat"})
+		return
+	}
+
+	// The job saves the partial answer as stopped once the agent loop returns
+	job.Cancel()
 
-	// Send final response
-	c.JSON(http.StatusOK, ChatResponse{
-		Message: response.Content[0].Text,
-		Topic:   chat.Title,
+	logs.Logger.Info("Cancelled generation",
+		zap.String("chat_uuid", job.ChatUUID),
+		zap.String("job_id", job.ID),
+		zap.String("message_uuid", job.MessageUUID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Generation cancelled",
+		"job_id":       job.ID,
+		"message_uuid": job.MessageUUID,
 	})
 }
 
@@ -258,8 +622,8 @@ func handleGetChatHistory(c *gin.Context) {
 	logs.Logger.Info("Fetching chat history",
 		zap.String("chat_uuid", uuid))
 
-	chat := &models.Chat{}
-	chat, err := chat.GetByUUID(uuid)
+	store := models.Default()
+	chat, err := store.Chats.GetByUUID(uuid)
 	if err != nil {
 		logs.Logger.Error("Failed to get chat",
 			zap.Error(err),
@@ -273,7 +637,7 @@ func handleGetChatHistory(c *gin.Context) {
 		zap.String("chat_title", chat.Title),
 		zap.String("chat_uuid", chat.UUID))
 
-	messages, err := chat.GetMessagesByChatID(chat.ID)
+	messages, err := store.Messages.GetActivePath(chat.ID)
 	if err != nil {
 		logs.Logger.Error("Failed to get chat messages",
 			zap.Error(err),
@@ -286,6 +650,40 @@ func handleGetChatHistory(c *gin.Context) {
 		zap.Int("message_count", len(messages)),
 		zap.Int("chat_id", chat.ID))
 
+	toolCalls, err := store.Tools.GetCallsByChatID(chat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get tool calls",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tool calls"})
+		return
+	}
+
+	// Nest tool calls under the assistant message that made them
+	byMessage := map[int][]models.ToolCall{}
+	for _, call := range toolCalls {
+		byMessage[call.MessageID] = append(byMessage[call.MessageID], call)
+	}
+	for i := range messages {
+		messages[i].ToolCalls = byMessage[messages[i].ID]
+	}
+
+	if err := attachFiles(chat.ID, messages); err != nil {
+		logs.Logger.Error("Failed to get chat files",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat files"})
+		return
+	}
+
+	if err := attachSiblings(chat.ID, messages); err != nil {
+		logs.Logger.Error("Failed to get message alternatives",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get message alternatives"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"chat":     chat,
 		"messages": messages,
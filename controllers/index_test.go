@@ -0,0 +1,359 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wisdomizer/models"
+	"wisdomizer/pkg/jobs"
+	"wisdomizer/pkg/llm"
+	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/stream"
+	"wisdomizer/pkg/uploads"
+	"wisdomizer/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// fakeProvider answers every request with a fixed reply and keeps the last request
+type fakeProvider struct {
+	last chan llm.Request
+}
+
+func (p fakeProvider) Name() string         { return "fake" }
+func (p fakeProvider) DefaultModel() string { return "fake-model" }
+
+func (p fakeProvider) Chat(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	p.last <- req
+	return &llm.Response{
+		Content:    "Hello back",
+		Blocks:     []llm.ContentBlock{{Type: llm.BlockText, Text: "Hello back"}},
+		StopReason: "end_turn",
+	}, nil
+}
+
+var provider = fakeProvider{last: make(chan llm.Request, 1)}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	logs.Logger = zap.NewNop()
+	validation.Init()
+	llm.Register(provider)
+
+	os.Exit(m.Run())
+}
+
+// newChatTest migrates a SQLite database of its own as the default store and returns a router on it,
+// a chat, an uploaded file waiting to be sent, and a second connection to the database
+func newChatTest(t *testing.T) (*gin.Engine, *models.Chat, *models.File, *sql.DB) {
+	dir := t.TempDir()
+	t.Setenv("UPLOADS_DIR", filepath.Join(dir, "uploads"))
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_PATH", filepath.Join(dir, "test.db"))
+	models.Init()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store := models.Default()
+	chat := &models.Chat{
+		UUID:         uuid.New().String(),
+		Title:        "Test",
+		Provider:     provider.Name(),
+		Model:        provider.DefaultModel(),
+		MaxTokens:    llm.DefaultMaxTokens,
+		SystemPrompt: "Answer briefly.",
+	}
+	if err := store.Chats.Create(chat); err != nil {
+		t.Fatal(err)
+	}
+
+	fileUUID := uuid.New().String()
+	path, size, err := uploads.Save(fileUUID, strings.NewReader("uploaded notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded := &models.File{
+		UUID:        fileUUID,
+		ChatID:      chat.ID,
+		Name:        "uploaded.txt",
+		Path:        path,
+		ContentType: "text/plain",
+		Size:        size,
+	}
+	if err := store.Files.Create(uploaded); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	Index(r)
+	return r, chat, uploaded, db
+}
+
+func postChat(r *gin.Engine, req ChatRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	request := httptest.NewRequest(http.MethodPost, "/chat", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return w
+}
+
+func TestHandleChat(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger string // breaks a write of the turn
+		status  int
+	}{
+		{
+			name:   "saves the turn",
+			status: http.StatusOK,
+		},
+		{
+			name: "rolls back when the reply cannot be saved",
+			trigger: `CREATE TRIGGER fail BEFORE INSERT ON messages WHEN new.role = 'assistant' BEGIN
+				SELECT RAISE(ABORT, 'insert failed');
+			END`,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "rolls back when the attachment cannot be saved",
+			trigger: `CREATE TRIGGER fail BEFORE INSERT ON files BEGIN
+				SELECT RAISE(ABORT, 'insert failed');
+			END`,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "rolls back when the upload cannot be attached",
+			trigger: `CREATE TRIGGER fail BEFORE UPDATE OF message_id ON files BEGIN
+				SELECT RAISE(ABORT, 'update failed');
+			END`,
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, chat, uploaded, db := newChatTest(t)
+			if tt.trigger != "" {
+				if _, err := db.Exec(tt.trigger); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := postChat(r, ChatRequest{
+				Message:   "Hello",
+				Topic:     chat.Title,
+				ChatUUID:  chat.UUID,
+				FileUUIDs: []string{uploaded.UUID},
+				File: &File{
+					Name:    "inline.txt",
+					Content: base64.StdEncoding.EncodeToString([]byte("inline notes")),
+					Type:    "text/plain",
+				},
+			})
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+
+			if tt.status != http.StatusOK {
+				assertRolledBack(t, chat, uploaded)
+				return
+			}
+			assertSaved(t, chat, uploaded)
+		})
+	}
+}
+
+func TestHandleChatAfterFailedReply(t *testing.T) {
+	r, chat, _, _ := newChatTest(t)
+
+	// A question whose reply failed before any text was generated
+	store := models.Default()
+	question := &models.Message{UUID: uuid.New().String(), ChatID: chat.ID, Role: "user", Content: "First question"}
+	if err := store.Messages.Create(question); err != nil {
+		t.Fatal(err)
+	}
+	failed := &models.Message{UUID: uuid.New().String(), ChatID: chat.ID, ParentID: question.ID, Role: "assistant", Status: models.MessageFailed}
+	if err := store.Messages.Create(failed); err != nil {
+		t.Fatal(err)
+	}
+
+	w := postChat(r, ChatRequest{Message: "Second question", Topic: chat.Title, ChatUUID: chat.UUID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	// Both questions are sent as a single user turn
+	req := <-provider.last
+	if len(req.Messages) != 1 || req.Messages[0].Role != llm.RoleUser {
+		t.Fatalf("messages = %+v, want a single user turn", req.Messages)
+	}
+	if text := req.Messages[0].Text(); !strings.Contains(text, "First question") || !strings.Contains(text, "Second question") {
+		t.Errorf("prompt = %q, want both questions", text)
+	}
+}
+
+// assertSaved checks the user message carries both files and the reply was generated with them
+func assertSaved(t *testing.T, chat *models.Chat, uploaded *models.File) {
+	t.Helper()
+
+	store := models.Default()
+	messages, err := store.Messages.GetTree(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("messages = %d, want 2", len(messages))
+	}
+	user, reply := messages[0], messages[1]
+	if user.Role != "user" || user.Content != "Hello" || user.SystemPromptVersion != 1 {
+		t.Errorf("user message = %+v", user)
+	}
+	if reply.Role != "assistant" || reply.ParentID != user.ID || reply.Status != models.MessageCompleted || reply.Content != "Hello back" {
+		t.Errorf("reply = %+v", reply)
+	}
+
+	files, err := store.Files.GetByChatID(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, file := range files {
+		if file.MessageID != user.ID {
+			t.Errorf("file %s attached to message %d, want %d", file.Name, file.MessageID, user.ID)
+		}
+		names[file.Name] = true
+	}
+	if !names[uploaded.Name] || !names["inline.txt"] {
+		t.Errorf("files = %v, want %s and inline.txt", names, uploaded.Name)
+	}
+
+	// The prompt of the topic is sent unchanged
+	req := <-provider.last
+	if req.System != chat.SystemPrompt {
+		t.Errorf("system = %q", req.System)
+	}
+	prompt := req.Messages[len(req.Messages)-1]
+	for _, want := range []string{"uploaded notes", "inline notes", "Hello"} {
+		if !strings.Contains(prompt.Text(), want) {
+			t.Errorf("prompt %q is missing %q", prompt.Text(), want)
+		}
+	}
+}
+
+// assertRolledBack checks nothing of the turn was kept
+func assertRolledBack(t *testing.T, chat *models.Chat, uploaded *models.File) {
+	t.Helper()
+
+	store := models.Default()
+	if messages, err := store.Messages.GetTree(chat.ID); err != nil || len(messages) != 0 {
+		t.Errorf("messages = %+v, %v, want none", messages, err)
+	}
+
+	files, err := store.Files.GetByChatID(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].UUID != uploaded.UUID || files[0].MessageID != 0 {
+		t.Errorf("files = %+v, want only the unsent upload", files)
+	}
+
+	// The inline attachment was stored before the turn and removed with it
+	entries, err := os.ReadDir(uploads.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), uploaded.UUID) {
+			t.Errorf("blob %s left behind", entry.Name())
+		}
+	}
+}
+
+// finishJob runs a job of chat publishing one delta per text and waits for it to finish
+func finishJob(t *testing.T, chat *models.Chat, texts ...string) *jobs.Job {
+	t.Helper()
+
+	job, err := jobs.New(chat.UUID, uuid.New().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Start(func(ctx context.Context) {
+		for _, text := range texts {
+			job.Publish(stream.EventDelta, stream.Delta{Text: text})
+		}
+	})
+
+	if err := job.Stream(context.Background(), 0, func(stream.Event) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	return job
+}
+
+func TestStreamChat(t *testing.T) {
+	r, chat, _, _ := newChatTest(t)
+	first := finishJob(t, chat, "first a", "first b")
+	latest := finishJob(t, chat, "latest a", "latest b", "latest c")
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+		status      int
+		want        string // replayed text, consecutive deltas are coalesced
+	}{
+		{name: "latest job from the start", status: http.StatusOK, want: "latest alatest blatest c"},
+		{name: "job from the start", query: "job_id=" + first.ID, status: http.StatusOK, want: "first afirst b"},
+		{name: "job after an event", query: "job_id=" + first.ID + "&after=1", status: http.StatusOK, want: "first b"},
+		{name: "job after Last-Event-ID", query: "job_id=" + latest.ID, lastEventID: "2", status: http.StatusOK, want: "latest c"},
+		{name: "after without a job", query: "after=1", status: http.StatusBadRequest},
+		{name: "Last-Event-ID without a job", lastEventID: "1", status: http.StatusBadRequest},
+		{name: "invalid event ID", query: "job_id=" + first.ID + "&after=x", status: http.StatusBadRequest},
+		{name: "unknown job", query: "job_id=" + uuid.New().String(), status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/chat/"+chat.UUID+"/stream?"+tt.query, nil)
+			if tt.lastEventID != "" {
+				request.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var got string
+			for _, line := range strings.Split(w.Body.String(), "\n") {
+				var delta stream.Delta
+				if data, ok := strings.CutPrefix(line, "data: "); ok && json.Unmarshal([]byte(data), &delta) == nil {
+					got += delta.Text
+				}
+			}
+			if got != tt.want {
+				t.Errorf("replayed = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"net/http"
+	"wisdomizer/models"
+	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+func Message(r *gin.Engine) {
+	r.POST("/messages/:uuid/regenerate", handleRegenerateMessage)
+	r.PUT("/messages/:uuid", validation.Validate[EditMessageRequest](), handleEditMessage)
+	r.POST("/messages/:uuid/select", handleSelectMessage)
+	r.GET("/messages/:uuid/alternatives", handleGetMessageAlternatives)
+}
+
+// handleRegenerateMessage answers the user message of an assistant reply again, as a sibling of that reply
+func handleRegenerateMessage(c *gin.Context) {
+	existingMessage, chat, ok := getMessageChat(c)
+	if !ok {
+		return
+	}
+
+	if existingMessage.Role != "assistant" || existingMessage.ParentID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only replies to a user message can be regenerated"})
+		return
+	}
+
+	job, chat, ok := reserveChat(c, chat)
+	if !ok {
+		return
+	}
+	defer job.Discard()
+
+	store := models.Default()
+	userMessage, err := store.Messages.GetByID(existingMessage.ParentID)
+	if err != nil {
+		logs.Logger.Error("Failed to get user message",
+			zap.Error(err),
+			zap.Int("message_id", existingMessage.ParentID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user message"})
+		return
+	}
+
+	// The new reply continues the branch that ends at the user message
+	aiMessage := newReply(chat, job, userMessage)
+	err = store.Transaction(func(tx *models.Store) error {
+		if err := tx.Chats.SetActiveMessage(chat.ID, userMessage.ID); err != nil {
+			return err
+		}
+		return tx.Messages.Create(aiMessage)
+	})
+	if err != nil {
+		logs.Logger.Error("Failed to create AI response",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create AI response"})
+		return
+	}
+
+	logs.Logger.Info("Regenerating reply",
+		zap.String("message_uuid", existingMessage.UUID),
+		zap.Int("chat_id", chat.ID))
+
+	generateReply(c, chat, job, userMessage, aiMessage)
+}
+
+// handleEditMessage stores an edited user message as a sibling of the original and answers it
+func handleEditMessage(c *gin.Context) {
+	payload, exists := c.Get("payload")
+	if !exists {
+		logs.Logger.Error("Invalid request payload")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	req := payload.(EditMessageRequest)
+
+	existingMessage, chat, ok := getMessageChat(c)
+	if !ok {
+		return
+	}
+
+	if existingMessage.Role != "user" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only user messages can be edited"})
+		return
+	}
+
+	job, chat, ok := reserveChat(c, chat)
+	if !ok {
+		return
+	}
+	defer job.Discard()
+
+	message := &models.Message{
+		UUID:                uuid.New().String(),
+		ChatID:              chat.ID,
+		ParentID:            existingMessage.ParentID,
+		Role:                "user",
+		Content:             req.Content,
+		SystemPromptVersion: chat.SystemPromptVersion,
+	}
+
+	// The edit carries the files of the original
+	files, err := copyFiles(c.Request.Context(), chat, existingMessage.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to copy message files",
+			zap.Error(err),
+			zap.String("message_uuid", existingMessage.UUID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save edited message"})
+		return
+	}
+
+	// The edit, its files and the pending reply to it are saved together
+	var aiMessage *models.Message
+	err = models.Default().Transaction(func(tx *models.Store) error {
+		if err := tx.Messages.Create(message); err != nil {
+			return err
+		}
+		for _, file := range files {
+			file.MessageID = message.ID
+			if err := tx.Files.Create(file); err != nil {
+				return err
+			}
+		}
+		aiMessage = newReply(chat, job, message)
+		return tx.Messages.Create(aiMessage)
+	})
+	if err != nil {
+		discardFiles(files)
+		logs.Logger.Error("Failed to save edited message",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save edited message"})
+		return
+	}
+
+	logs.Logger.Info("Saved edited message",
+		zap.String("message_uuid", message.UUID),
+		zap.String("edited_uuid", existingMessage.UUID),
+		zap.Int("chat_id", chat.ID))
+
+	generateReply(c, chat, job, message, aiMessage)
+}
+
+// handleSelectMessage makes the newest branch through a message the active one
+func handleSelectMessage(c *gin.Context) {
+	existingMessage, chat, ok := getMessageChat(c)
+	if !ok {
+		return
+	}
+
+	// Switching branches under a running generation would change its history
+	job, chat, ok := reserveChat(c, chat)
+	if !ok {
+		return
+	}
+	defer job.Discard()
+
+	store := models.Default()
+	leafID, err := store.Messages.GetLatestLeaf(existingMessage.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get branch",
+			zap.Error(err),
+			zap.String("message_uuid", existingMessage.UUID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get branch"})
+		return
+	}
+
+	if err := store.Chats.SetActiveMessage(chat.ID, leafID); err != nil {
+		logs.Logger.Error("Failed to select branch",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to select branch"})
+		return
+	}
+
+	messages, err := store.Messages.GetActivePath(chat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get chat messages",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat messages"})
+		return
+	}
+
+	if err := attachSiblings(chat.ID, messages); err != nil {
+		logs.Logger.Error("Failed to get message alternatives",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get message alternatives"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+// handleGetMessageAlternatives lists a message and its siblings, marking the one on the active branch
+func handleGetMessageAlternatives(c *gin.Context) {
+	existingMessage, chat, ok := getMessageChat(c)
+	if !ok {
+		return
+	}
+
+	store := models.Default()
+	siblings, err := store.Messages.GetSiblings(*existingMessage)
+	if err != nil {
+		logs.Logger.Error("Failed to get message alternatives",
+			zap.Error(err),
+			zap.String("message_uuid", existingMessage.UUID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get message alternatives"})
+		return
+	}
+
+	path, err := store.Messages.GetActivePath(chat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get chat messages",
+			zap.Error(err),
+			zap.Int("chat_id", chat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat messages"})
+		return
+	}
+
+	activeUUID := ""
+	for _, sibling := range siblings {
+		for _, msg := range path {
+			if msg.ID == sibling.ID {
+				activeUUID = sibling.UUID
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":    siblings,
+		"active_uuid": activeUUID,
+	})
+}
+
+// getMessageChat loads the message named in the route and its chat, writing the error response on failure
+func getMessageChat(c *gin.Context) (*models.Message, *models.Chat, bool) {
+	store := models.Default()
+	existingMessage, err := store.Messages.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get message", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return nil, nil, false
+	}
+
+	existingChat, err := store.Chats.GetByID(existingMessage.ChatID)
+	if err != nil {
+		logs.Logger.Error("Failed to get chat",
+			zap.Error(err),
+			zap.Int("chat_id", existingMessage.ChatID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat"})
+		return nil, nil, false
+	}
+
+	return existingMessage, existingChat, true
+}
+
+// attachSiblings lists the alternatives of every message, so clients can walk the branches
+func attachSiblings(chatID int, messages []models.Message) error {
+	tree, err := models.Default().Messages.GetTree(chatID)
+	if err != nil {
+		return err
+	}
+
+	byParent := map[int][]string{}
+	for _, msg := range tree {
+		byParent[msg.ParentID] = append(byParent[msg.ParentID], msg.UUID)
+	}
+	for i := range messages {
+		messages[i].Siblings = byParent[messages[i].ParentID]
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+	"wisdomizer/models"
+	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type SearchRequest struct {
+	Q     string `form:"q" binding:"required"`
+	Role  string `form:"role" binding:"omitempty,oneof=user assistant"`
+	From  string `form:"from"` // RFC 3339 time or YYYY-MM-DD
+	To    string `form:"to"`   // RFC 3339 time or YYYY-MM-DD, a date includes the whole day
+	Limit int    `form:"limit" binding:"omitempty,min=1"`
+}
+
+func Search(r *gin.Engine) {
+	r.GET("/search", validation.Validate[SearchRequest](), handleSearch)
+}
+
+func handleSearch(c *gin.Context) {
+	// Get validated payload from context
+	payload, exists := c.Get("payload")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	req := payload.(SearchRequest)
+
+	query := models.SearchQuery{
+		Text:  req.Q,
+		Role:  req.Role,
+		Limit: min(req.Limit, maxSearchLimit),
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultSearchLimit
+	}
+
+	var err error
+	if query.From, err = parseSearchTime(req.From, false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date (YYYY-MM-DD) or an RFC 3339 time"})
+		return
+	}
+	if query.To, err = parseSearchTime(req.To, true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date (YYYY-MM-DD) or an RFC 3339 time"})
+		return
+	}
+
+	hits, err := models.Default().Search.Search(query)
+	if err != nil {
+		logs.Logger.Error("Failed to search", zap.Error(err), zap.String("query", req.Q))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+		return
+	}
+
+	if hits == nil {
+		hits = []models.SearchHit{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query": req.Q,
+		"hits":  hits,
+	})
+}
+
+// parseSearchTime reads a date range bound, a date given as the end of the range includes that day
+func parseSearchTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
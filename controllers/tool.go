@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"wisdomizer/models"
+	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/tools"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type ToolResponse struct {
+	UUID        string          `json:"uuid"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+	Enabled     bool            `json:"enabled"`
+	Mutating    bool            `json:"mutating"`
+}
+
+type ToolCallDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+func Tool(r *gin.Engine) {
+	r.GET("/tools", handleListTools)
+	r.GET("/topics/:uuid/tools", handleGetTopicTools)
+	r.PUT("/topics/:uuid/tools/:name", handleEnableTopicTool)
+	r.DELETE("/topics/:uuid/tools/:name", handleDisableTopicTool)
+	r.GET("/tool-calls/:uuid", handleGetToolCall)
+	r.POST("/tool-calls/:uuid/approve", handleApproveToolCall)
+	r.POST("/tool-calls/:uuid/deny", handleDenyToolCall)
+}
+
+func handleListTools(c *gin.Context) {
+	all, err := models.Default().Tools.GetAll()
+	if err != nil {
+		logs.Logger.Error("Failed to get tools", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tools"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tools": newToolResponses(all, nil)})
+}
+
+func handleGetTopicTools(c *gin.Context) {
+	// Get chat by UUID
+	existingChat, err := models.Default().Chats.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get chat", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return
+	}
+
+	all, err := models.Default().Tools.GetAll()
+	if err != nil {
+		logs.Logger.Error("Failed to get tools", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tools"})
+		return
+	}
+
+	enabled, err := models.Default().Tools.GetByChatID(existingChat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get chat tools",
+			zap.Error(err),
+			zap.Int("chat_id", existingChat.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get topic tools"})
+		return
+	}
+
+	enabledNames := map[string]bool{}
+	for _, t := range enabled {
+		enabledNames[t.Name] = true
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tools": newToolResponses(all, enabledNames)})
+}
+
+func handleEnableTopicTool(c *gin.Context) {
+	existingChat, existingTool, ok := getTopicTool(c)
+	if !ok {
+		return
+	}
+
+	if err := models.Default().Tools.AddToChat(existingChat.ID, existingTool.ID); err != nil {
+		logs.Logger.Error("Failed to enable tool",
+			zap.Error(err),
+			zap.Int("chat_id", existingChat.ID),
+			zap.String("tool", existingTool.Name))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable tool"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tool enabled successfully"})
+}
+
+func handleDisableTopicTool(c *gin.Context) {
+	existingChat, existingTool, ok := getTopicTool(c)
+	if !ok {
+		return
+	}
+
+	if err := models.Default().Tools.RemoveFromChat(existingChat.ID, existingTool.ID); err != nil {
+		logs.Logger.Error("Failed to disable tool",
+			zap.Error(err),
+			zap.Int("chat_id", existingChat.ID),
+			zap.String("tool", existingTool.Name))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable tool"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tool disabled successfully"})
+}
+
+func handleGetToolCall(c *gin.Context) {
+	existingToolCall, err := models.Default().Tools.GetCallByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get tool call", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tool call not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, existingToolCall)
+}
+
+func handleApproveToolCall(c *gin.Context) {
+	decideToolCall(c, true)
+}
+
+func handleDenyToolCall(c *gin.Context) {
+	decideToolCall(c, false)
+}
+
+// decideToolCall records the user's decision and hands it to the agent loop waiting on the tool call
+func decideToolCall(c *gin.Context, approved bool) {
+	var req ToolCallDecisionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	existingToolCall, err := models.Default().Tools.GetCallByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get tool call", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tool call not found"})
+		return
+	}
+
+	approval := models.ApprovalDenied
+	if approved {
+		approval = models.ApprovalApproved
+	}
+
+	// Only one decision wins, a timeout or a second click finds the row already decided
+	if err := models.Default().Tools.SetCallApproval(existingToolCall.UUID, approval); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Tool call is not awaiting approval", "tool_call": existingToolCall})
+		return
+	}
+
+	if !tools.Decide(existingToolCall.UUID, approved, req.Reason) {
+		// The agent loop that asked is gone, nothing will run the tool
+		if err := models.Default().Tools.UpdateCallResult(existingToolCall.UUID, "approval arrived after the agent loop stopped", models.ToolCallFailed); err != nil {
+			logs.Logger.Error("Failed to fail tool call",
+				zap.Error(err),
+				zap.String("tool_call_uuid", existingToolCall.UUID))
+		}
+		c.JSON(http.StatusGone, gin.H{"error": "Tool call is no longer running"})
+		return
+	}
+
+	logs.Logger.Info("Tool call decided",
+		zap.String("tool_call_uuid", existingToolCall.UUID),
+		zap.String("approval", approval))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tool call " + approval})
+}
+
+// getTopicTool loads the chat and tool named in the route, writing the error response on failure
+func getTopicTool(c *gin.Context) (*models.Chat, *models.Tool, bool) {
+	existingChat, err := models.Default().Chats.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get chat", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return nil, nil, false
+	}
+
+	existingTool, err := models.Default().Tools.GetByName(c.Param("name"))
+	if err != nil {
+		logs.Logger.Error("Failed to get tool", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tool"})
+		return nil, nil, false
+	}
+
+	if existingTool == nil || !tools.IsRegistered(existingTool.Name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tool not found"})
+		return nil, nil, false
+	}
+
+	return existingChat, existingTool, true
+}
+
+// newToolResponses lists the tools that still have a Go implementation
+func newToolResponses(all []models.Tool, enabled map[string]bool) []ToolResponse {
+	responses := []ToolResponse{}
+	for _, tool := range all {
+		spec, ok := tools.Lookup(tool.Name)
+		if !ok {
+			continue
+		}
+
+		responses = append(responses, ToolResponse{
+			UUID:        tool.UUID,
+			Name:        tool.Name,
+			Description: tool.Description,
+			Schema:      json.RawMessage(tool.Schema),
+			Enabled:     enabled[tool.Name],
+			Mutating:    spec.Mutating,
+		})
+	}
+
+	return responses
+}
@@ -1,9 +1,12 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
 	"wisdomizer/models"
+	"wisdomizer/pkg/llm"
 	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/uploads"
 	"wisdomizer/pkg/validation"
 
 	"github.com/gin-gonic/gin"
@@ -12,25 +15,42 @@ import (
 )
 
 type CreateTopicRequest struct {
-	Title       string `json:"title" binding:"required"`
-	Description string `json:"description,omitempty"`
+	Title        string   `json:"title" binding:"required"`
+	Description  string   `json:"description,omitempty"`
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+	Provider     string   `json:"provider,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Temperature  *float64 `json:"temperature,omitempty" binding:"omitempty,min=0,max=2"`
+	MaxTokens    int      `json:"max_tokens,omitempty" binding:"omitempty,min=1"`
 }
 
 type UpdateTopicRequest struct {
-	Title string `json:"title" binding:"required"`
+	Title        string   `json:"title" binding:"required"`
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+	Provider     string   `json:"provider,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Temperature  *float64 `json:"temperature,omitempty" binding:"omitempty,min=0,max=2"`
+	MaxTokens    int      `json:"max_tokens,omitempty" binding:"omitempty,min=1"`
 }
 
 type TopicResponse struct {
-	ID          int    `json:"id"`
-	UUID        string `json:"uuid"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	ID                  int     `json:"id"`
+	UUID                string  `json:"uuid"`
+	Title               string  `json:"title"`
+	Description         string  `json:"description"`
+	Provider            string  `json:"provider"`
+	Model               string  `json:"model"`
+	Temperature         float64 `json:"temperature"`
+	MaxTokens           int     `json:"max_tokens"`
+	SystemPrompt        string  `json:"system_prompt"`
+	SystemPromptVersion int     `json:"system_prompt_version"`
 }
 
 func Topic(r *gin.Engine) {
 	r.POST("/topics", validation.Validate[CreateTopicRequest](), handleCreateTopic)
 	r.PUT("/topics/:uuid", validation.Validate[UpdateTopicRequest](), handleUpdateTopic)
 	r.DELETE("/topics/:uuid", handleDeleteTopic)
+	r.GET("/topics/:uuid/system-prompts", handleGetSystemPrompts)
 }
 
 func handleCreateTopic(c *gin.Context) {
@@ -42,40 +62,33 @@ func handleCreateTopic(c *gin.Context) {
 	}
 	req := payload.(CreateTopicRequest)
 
-	// Get or create chat
-	chat, err := models.NewChat()
-	if err != nil {
-		logs.Logger.Error("Failed to initialize chat", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize chat"})
-		return
-	}
-
 	// Create new chat with topic information
+	chat := &models.Chat{}
 	chat.UUID = uuid.New().String()
 	chat.Title = req.Title
 	chat.Description = req.Description
+	chat.Provider = llm.DefaultProvider
+	chat.Temperature = llm.DefaultTemperature
+	chat.MaxTokens = llm.DefaultMaxTokens
+	chat.SystemPrompt = llm.DefaultSystem
 
-	if err := chat.Create(*chat); err != nil {
-		logs.Logger.Error("Failed to create chat", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat"})
+	if req.SystemPrompt != "" {
+		chat.SystemPrompt = req.SystemPrompt
+	}
+
+	if err := applyModelSettings(chat, req.Provider, req.Model, req.Temperature, req.MaxTokens); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Get the created chat to ensure we have the correct ID
-	createdChat, err := chat.GetByUUID(chat.UUID)
-	if err != nil {
-		logs.Logger.Error("Failed to get created chat", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get created chat"})
+	if err := models.Default().Chats.Create(chat); err != nil {
+		logs.Logger.Error("Failed to create chat", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat"})
 		return
 	}
 
 	// Return the created topic
-	c.JSON(http.StatusCreated, TopicResponse{
-		ID:          createdChat.ID,
-		UUID:        createdChat.UUID,
-		Title:       createdChat.Title,
-		Description: createdChat.Description,
-	})
+	c.JSON(http.StatusCreated, newTopicResponse(chat))
 }
 
 func handleUpdateTopic(c *gin.Context) {
@@ -88,47 +101,141 @@ func handleUpdateTopic(c *gin.Context) {
 	req := payload.(UpdateTopicRequest)
 
 	// Get chat by UUID
-	chat := &models.Chat{}
-	existingChat, err := chat.GetByUUID(c.Param("uuid"))
+	existingChat, err := models.Default().Chats.GetByUUID(c.Param("uuid"))
 	if err != nil {
 		logs.Logger.Error("Failed to get chat", zap.Error(err))
 		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
 		return
 	}
 
-	// Update chat title
+	// Update chat title and model settings
 	existingChat.Title = req.Title
-	if err := existingChat.Update(); err != nil {
+	if err := applyModelSettings(existingChat, req.Provider, req.Model, req.Temperature, req.MaxTokens); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The settings and a changed system prompt, stored as a new version, are saved together
+	err = models.Default().Transaction(func(tx *models.Store) error {
+		if err := tx.Chats.Update(existingChat); err != nil {
+			return err
+		}
+		if req.SystemPrompt != "" && req.SystemPrompt != existingChat.SystemPrompt {
+			return tx.Chats.UpdateSystemPrompt(existingChat, req.SystemPrompt)
+		}
+		return nil
+	})
+	if err != nil {
 		logs.Logger.Error("Failed to update chat", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update topic"})
 		return
 	}
 
 	// Return the updated topic
-	c.JSON(http.StatusOK, TopicResponse{
-		ID:          existingChat.ID,
-		UUID:        existingChat.UUID,
-		Title:       existingChat.Title,
-		Description: existingChat.Description,
-	})
+	c.JSON(http.StatusOK, newTopicResponse(existingChat))
 }
 
 func handleDeleteTopic(c *gin.Context) {
 	// Get chat by UUID
-	chat := &models.Chat{}
-	existingChat, err := chat.GetByUUID(c.Param("uuid"))
+	existingChat, err := models.Default().Chats.GetByUUID(c.Param("uuid"))
 	if err != nil {
 		logs.Logger.Error("Failed to get chat", zap.Error(err))
 		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
 		return
 	}
 
+	// The file rows go with the chat, their blobs are removed afterwards
+	files, err := models.Default().Files.GetByChatID(existingChat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get files", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete topic"})
+		return
+	}
+
 	// Delete chat
-	if err := existingChat.Delete(); err != nil {
+	if err := models.Default().Chats.Delete(existingChat.UUID); err != nil {
 		logs.Logger.Error("Failed to delete chat", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete topic"})
 		return
 	}
 
+	for _, file := range files {
+		if err := uploads.Remove(file.Path); err != nil {
+			logs.Logger.Warn("Failed to remove file", zap.Error(err), zap.String("path", file.Path))
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Topic deleted successfully"})
 }
+
+func handleGetSystemPrompts(c *gin.Context) {
+	// Get chat by UUID
+	existingChat, err := models.Default().Chats.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get chat", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return
+	}
+
+	prompts, err := models.Default().Chats.GetSystemPrompts(existingChat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get system prompts", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get system prompts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"current":  existingChat.SystemPromptVersion,
+		"versions": prompts,
+	})
+}
+
+// applyModelSettings overrides the chat generation settings that were provided.
+// Switching provider without a model falls back to that provider's default model, the temperature
+// must be in the range of the resulting provider.
+func applyModelSettings(chat *models.Chat, providerName string, model string, temperature *float64, maxTokens int) error {
+	if providerName != "" && providerName != chat.Provider {
+		chat.Provider = providerName
+		chat.Model = ""
+	}
+
+	provider, err := llm.Get(chat.Provider)
+	if err != nil {
+		return err
+	}
+
+	if model != "" {
+		chat.Model = model
+	}
+	if chat.Model == "" {
+		chat.Model = provider.DefaultModel()
+	}
+
+	if temperature != nil {
+		chat.Temperature = *temperature
+	}
+	if limiter, ok := provider.(llm.TemperatureLimiter); ok && chat.Temperature > limiter.MaxTemperature() {
+		return fmt.Errorf("temperature must be between 0 and %g for %s", limiter.MaxTemperature(), chat.Provider)
+	}
+
+	if maxTokens > 0 {
+		chat.MaxTokens = maxTokens
+	}
+
+	return nil
+}
+
+func newTopicResponse(chat *models.Chat) TopicResponse {
+	return TopicResponse{
+		ID:                  chat.ID,
+		UUID:                chat.UUID,
+		Title:               chat.Title,
+		Description:         chat.Description,
+		Provider:            chat.Provider,
+		Model:               chat.Model,
+		Temperature:         chat.Temperature,
+		MaxTokens:           chat.MaxTokens,
+		SystemPrompt:        chat.SystemPrompt,
+		SystemPromptVersion: chat.SystemPromptVersion,
+	}
+}
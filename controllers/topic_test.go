@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wisdomizer/models"
+	"wisdomizer/pkg/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedProvider accepts temperatures up to 1, like Anthropic
+type limitedProvider struct {
+	fakeProvider
+}
+
+func (p limitedProvider) Name() string            { return "limited" }
+func (p limitedProvider) MaxTemperature() float64 { return 1 }
+
+func TestTopicTemperature(t *testing.T) {
+	llm.Register(limitedProvider{provider})
+
+	tests := []struct {
+		name    string
+		method  string
+		body    gin.H
+		status  int
+		want    float64 // temperature of the topic afterwards
+		current gin.H   // settings of the topic updated, for PUT
+	}{
+		{
+			name:   "create above the provider limit",
+			method: http.MethodPost,
+			body:   gin.H{"title": "T", "provider": "limited", "temperature": 1.5},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "create at the provider limit",
+			method: http.MethodPost,
+			body:   gin.H{"title": "T", "provider": "limited", "temperature": 1},
+			status: http.StatusCreated,
+			want:   1,
+		},
+		{
+			name:   "create above 1 on a provider without a limit",
+			method: http.MethodPost,
+			body:   gin.H{"title": "T", "provider": "fake", "temperature": 1.5},
+			status: http.StatusCreated,
+			want:   1.5,
+		},
+		{
+			name:   "create above 2",
+			method: http.MethodPost,
+			body:   gin.H{"title": "T", "provider": "fake", "temperature": 2.5},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:    "update above the provider limit",
+			method:  http.MethodPut,
+			current: gin.H{"provider": "limited", "temperature": 0.5},
+			body:    gin.H{"title": "T", "temperature": 1.2},
+			status:  http.StatusBadRequest,
+			want:    0.5,
+		},
+		{
+			name:    "switch to a provider below the current temperature",
+			method:  http.MethodPut,
+			current: gin.H{"provider": "fake", "temperature": 1.5},
+			body:    gin.H{"title": "T", "provider": "limited"},
+			status:  http.StatusBadRequest,
+			want:    1.5,
+		},
+		{
+			name:    "switch provider with a temperature in range",
+			method:  http.MethodPut,
+			current: gin.H{"provider": "fake", "temperature": 1.5},
+			body:    gin.H{"title": "T", "provider": "limited", "temperature": 0.2},
+			status:  http.StatusOK,
+			want:    0.2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, chat, _, _ := newChatTest(t)
+			r := gin.New()
+			Topic(r)
+
+			path := "/topics"
+			if tt.method == http.MethodPut {
+				path += "/" + chat.UUID
+				current := gin.H{"title": "T"}
+				for key, value := range tt.current {
+					current[key] = value
+				}
+				if w := sendJSON(r, http.MethodPut, path, current); w.Code != http.StatusOK {
+					t.Fatalf("setup status = %d: %s", w.Code, w.Body.String())
+				}
+			}
+
+			w := sendJSON(r, tt.method, path, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.method == http.MethodPost && tt.status != http.StatusCreated {
+				return
+			}
+
+			uuid := chat.UUID
+			if tt.method == http.MethodPost {
+				var topic TopicResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &topic); err != nil {
+					t.Fatal(err)
+				}
+				uuid = topic.UUID
+			}
+
+			saved, err := models.Default().Chats.GetByUUID(uuid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Temperature != tt.want {
+				t.Errorf("temperature = %v, want %v", saved.Temperature, tt.want)
+			}
+		})
+	}
+}
+
+func sendJSON(r *gin.Engine, method string, path string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	request := httptest.NewRequest(method, path, bytes.NewReader(data))
+	request.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return w
+}
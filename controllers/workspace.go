@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"wisdomizer/models"
+	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type AddWorkspaceRequest struct {
+	Path string `json:"path" binding:"required"`
+	Mode string `json:"mode" binding:"required,oneof=read write"`
+	// MaxFileSize overrides TOOLS_MAX_FILE_SIZE for the files of this root
+	MaxFileSize int64 `json:"max_file_size" binding:"omitempty,min=1"`
+}
+
+func Workspace(r *gin.Engine) {
+	r.GET("/topics/:uuid/workspaces", handleGetWorkspaces)
+	r.POST("/topics/:uuid/workspaces", validation.Validate[AddWorkspaceRequest](), handleAddWorkspace)
+	r.DELETE("/topics/:uuid/workspaces/:id", handleDeleteWorkspace)
+}
+
+func handleGetWorkspaces(c *gin.Context) {
+	// Get chat by UUID
+	existingChat, err := models.Default().Chats.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get chat", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return
+	}
+
+	roots, err := models.Default().Chats.GetWorkspaceRoots(existingChat.ID)
+	if err != nil {
+		logs.Logger.Error("Failed to get workspace roots", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get workspaces"})
+		return
+	}
+
+	if roots == nil {
+		roots = []models.WorkspaceRoot{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workspaces": roots})
+}
+
+func handleAddWorkspace(c *gin.Context) {
+	// Get validated payload from context
+	payload, exists := c.Get("payload")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	req := payload.(AddWorkspaceRequest)
+
+	// Get chat by UUID
+	existingChat, err := models.Default().Chats.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get chat", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return
+	}
+
+	// Roots are stored resolved so tool paths can be compared against them
+	if !filepath.IsAbs(req.Path) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path must be absolute"})
+		return
+	}
+
+	path, err := filepath.EvalSymlinks(filepath.Clean(req.Path))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path does not exist"})
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path must be a directory"})
+		return
+	}
+
+	root := &models.WorkspaceRoot{
+		ChatID:      existingChat.ID,
+		Path:        path,
+		Mode:        req.Mode,
+		MaxFileSize: req.MaxFileSize,
+	}
+	if err := models.Default().Chats.CreateWorkspaceRoot(root); err != nil {
+		logs.Logger.Error("Failed to add workspace root", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add workspace"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":            root.ID,
+		"path":          path,
+		"mode":          req.Mode,
+		"max_file_size": req.MaxFileSize,
+	})
+}
+
+func handleDeleteWorkspace(c *gin.Context) {
+	// Get chat by UUID
+	existingChat, err := models.Default().Chats.GetByUUID(c.Param("uuid"))
+	if err != nil {
+		logs.Logger.Error("Failed to get chat", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return
+	}
+
+	if err := models.Default().Chats.DeleteWorkspaceRoot(existingChat.ID, id); err != nil {
+		logs.Logger.Error("Failed to delete workspace root", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace removed successfully"})
+}
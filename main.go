@@ -7,12 +7,17 @@ import (
 	"path/filepath"
 	"wisdomizer/controllers"
 	"wisdomizer/models"
+	"wisdomizer/pkg/llm"
 	"wisdomizer/pkg/logs"
+	"wisdomizer/pkg/tools"
 	"wisdomizer/pkg/validation"
+	"wisdomizer/pkg/vendors/anthropic"
+	"wisdomizer/pkg/vendors/openai"
 
 	"github.com/gin-contrib/multitemplate"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
 )
 
 func init() {
@@ -31,9 +36,39 @@ func init() {
 		log.Fatalf("Error loading .env file: %s", err)
 	}
 
+	// The migrate command runs instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(os.Args[2:]))
+	}
+
 	models.Init()
 	logs.Init()
 	validation.Init()
+
+	llm.Register(anthropic.Provider{})
+	llm.Register(openai.Provider{})
+
+	if err := tools.Sync(); err != nil {
+		log.Fatalf("Error syncing tools: %s", err)
+	}
+
+	if err := tools.ExpireApprovals(); err != nil {
+		log.Fatalf("Error expiring tool approvals: %s", err)
+	}
+
+	if err := models.Default().Messages.FailPending(); err != nil {
+		log.Fatalf("Error failing interrupted generations: %s", err)
+	}
+
+	// Search still works without the index, but scans every row
+	missing, err := models.MissingSearchIndexes()
+	if err != nil {
+		log.Fatalf("Error checking the search index: %s", err)
+	}
+	if len(missing) > 0 {
+		logs.Logger.Warn("Search index is missing, build with -tags sqlite_fts5 (make build) to create it",
+			zap.Strings("tables", missing))
+	}
 }
 
 func main() {
@@ -73,7 +108,12 @@ func main() {
 	// add here new controller
 	// -----------------------
 	controllers.Index(r)
+	controllers.Files(r)
+	controllers.Message(r)
+	controllers.Search(r)
 	controllers.Topic(r)
+	controllers.Tool(r)
+	controllers.Workspace(r)
 
 	// not found
 	r.NoRoute(func(ctx *gin.Context) {
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"wisdomizer/models"
+)
+
+// migrate runs `wisdomizer migrate [status|up]` and returns the exit code
+func migrate(args []string) int {
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	models.Connect()
+
+	switch command {
+	case "status":
+	case "up":
+		if err := models.Migrate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error migrating database: %s\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown migrate command: %s\nUsage: wisdomizer migrate [status|up]\n", command)
+		return 2
+	}
+
+	states, err := models.MigrationStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading migrations: %s\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	pending := 0
+	for _, state := range states {
+		appliedAt := "pending"
+		if state.AppliedAt.IsZero() {
+			pending++
+		} else {
+			appliedAt = state.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", state.Version, state.Name, appliedAt)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d applied, %d pending\n", len(states)-pending, pending)
+	return 0
+}
@@ -1,36 +1,60 @@
 package models
 
 import (
+	"database/sql"
 	"fmt"
 	"time"
 )
 
 type Chat struct {
-	ID          int       `json:"id"`
-	UUID        string    `json:"uuid"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                  int       `json:"id"`
+	UUID                string    `json:"uuid"`
+	Title               string    `json:"title"`
+	Description         string    `json:"description"`
+	Provider            string    `json:"provider"`
+	Model               string    `json:"model"`
+	Temperature         float64   `json:"temperature"`
+	MaxTokens           int       `json:"max_tokens"`
+	SystemPrompt        string    `json:"system_prompt"`
+	SystemPromptVersion int       `json:"system_prompt_version"`
+	ActiveMessageID     int       `json:"active_message_id,omitempty"` // leaf of the selected branch
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
 }
 
-type Message struct {
+type SystemPrompt struct {
 	ID        int       `json:"id"`
-	UUID      string    `json:"uuid"`
 	ChatID    int       `json:"chat_id"`
-	Role      string    `json:"role"` // user, assistant, system
+	Version   int       `json:"version"`
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+type Message struct {
+	ID                  int        `json:"id"`
+	UUID                string     `json:"uuid"`
+	ChatID              int        `json:"chat_id"`
+	ParentID            int        `json:"parent_id,omitempty"` // previous message of the branch, 0 for the first
+	Role                string     `json:"role"`                // user, assistant, system
+	Content             string     `json:"content"`
+	SystemPromptVersion int        `json:"system_prompt_version"` // chat system prompt version in effect
+	Status              string     `json:"status"`                // pending, completed, stopped, failed
+	ToolCalls           []ToolCall `json:"tool_calls,omitempty"`  // loaded separately, not a column
+	Files               []File     `json:"files,omitempty"`       // loaded separately, not a column
+	Siblings            []string   `json:"siblings,omitempty"`    // UUIDs of the alternatives including this one, not a column
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
 type File struct {
 	ID          int       `json:"id"`
 	UUID        string    `json:"uuid"`
 	ChatID      int       `json:"chat_id"`
+	MessageID   int       `json:"message_id,omitempty"` // user message that carried the file
 	Name        string    `json:"name"`
 	Path        string    `json:"path"`
 	ContentType string    `json:"content_type"`
 	Size        int64     `json:"size"`
+	Text        string    `json:"-"` // searchable text of text files and extracted documents
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -50,429 +74,435 @@ type ChatTool struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-type ToolCall struct {
-	ID         int       `json:"id"`
-	UUID       string    `json:"uuid"`
-	MessageID  int       `json:"message_id"`
-	ToolID     int       `json:"tool_id"`
-	Input      string    `json:"input"`
-	Output     string    `json:"output"`
-	Status     string    `json:"status"` // pending, completed, failed
-	StartedAt  time.Time `json:"started_at"`
-	FinishedAt time.Time `json:"finished_at"`
-	CreatedAt  time.Time `json:"created_at"`
-}
+// Message statuses, assistant messages are pending while they are generated
+const (
+	MessagePending   = "pending"
+	MessageCompleted = "completed"
+	MessageStopped   = "stopped"
+	MessageFailed    = "failed"
+)
 
-func NewChat() (*Chat, error) {
-	_, err := client.Exec(`
-	CREATE TABLE IF NOT EXISTS chats (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		uuid TEXT UNIQUE,
-		title TEXT,
-		description TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create chats table: %v", err)
-	}
+// Tool call statuses
+const (
+	ToolCallPending   = "pending"
+	ToolCallCompleted = "completed"
+	ToolCallFailed    = "failed"
+)
 
-	_, err = client.Exec(`
-	CREATE TABLE IF NOT EXISTS messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		uuid TEXT UNIQUE,
-		chat_id INTEGER,
-		role TEXT NOT NULL,
-		content TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
-	)`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create messages table: %v", err)
-	}
+// Tool call approvals, empty when the tool needs none
+const (
+	ApprovalRequired = "required"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+	ApprovalExpired  = "expired"
+)
 
-	_, err = client.Exec(`
-	CREATE TABLE IF NOT EXISTS files (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		uuid TEXT UNIQUE,
-		chat_id INTEGER,
-		name TEXT NOT NULL,
-		path TEXT NOT NULL,
-		content_type TEXT,
-		size INTEGER,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
-	)`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create files table: %v", err)
-	}
+type ToolCall struct {
+	ID                int       `json:"id"`
+	UUID              string    `json:"uuid"`
+	MessageID         int       `json:"message_id"`
+	ToolID            int       `json:"tool_id"`
+	ToolName          string    `json:"tool_name"` // joined from tools, not a column
+	Input             string    `json:"input"`
+	Output            string    `json:"output"`
+	Status            string    `json:"status"`             // pending, completed, failed
+	Approval          string    `json:"approval,omitempty"` // required, approved, denied, expired
+	Preview           string    `json:"preview,omitempty"`  // proposed change shown for approval
+	ApprovalExpiresAt time.Time `json:"approval_expires_at,omitempty"`
+	StartedAt         time.Time `json:"started_at"`
+	FinishedAt        time.Time `json:"finished_at"`
+	CreatedAt         time.Time `json:"created_at"`
+}
 
-	_, err = client.Exec(`
-	CREATE TABLE IF NOT EXISTS tools (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		uuid TEXT UNIQUE,
-		name TEXT NOT NULL,
-		description TEXT,
-		schema TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create tools table: %v", err)
-	}
+// Workspace root modes
+const (
+	WorkspaceReadOnly  = "read"
+	WorkspaceReadWrite = "write"
+)
 
-	_, err = client.Exec(`
-	CREATE TABLE IF NOT EXISTS chat_tools (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		chat_id INTEGER,
-		tool_id INTEGER,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(chat_id, tool_id),
-		FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
-		FOREIGN KEY (tool_id) REFERENCES tools(id) ON DELETE CASCADE
-	)`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create chat_tools table: %v", err)
-	}
+type WorkspaceRoot struct {
+	ID          int       `json:"id"`
+	ChatID      int       `json:"chat_id"`
+	Path        string    `json:"path"`
+	Mode        string    `json:"mode"`          // read, write
+	MaxFileSize int64     `json:"max_file_size"` // 0 uses TOOLS_MAX_FILE_SIZE
+	CreatedAt   time.Time `json:"created_at"`
+}
 
-	_, err = client.Exec(`
-	CREATE TABLE IF NOT EXISTS tool_calls (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		uuid TEXT UNIQUE,
-		message_id INTEGER,
-		tool_id INTEGER,
-		input TEXT,
-		output TEXT,
-		status TEXT DEFAULT 'pending',
-		started_at TIMESTAMP,
-		finished_at TIMESTAMP,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
-		FOREIGN KEY (tool_id) REFERENCES tools(id) ON DELETE CASCADE
-	)`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create tool_calls table: %v", err)
-	}
+// ChatStore represents the persistence of chats, their system prompt history and workspace roots
+type ChatStore interface {
+	Create(chat *Chat) error
+	GetByUUID(uuid string) (*Chat, error)
+	GetByID(id int) (*Chat, error)
+	GetAll() ([]Chat, error)
+	Update(chat *Chat) error
+	Delete(uuid string) error
+	UpdateSystemPrompt(chat *Chat, content string) error
+	GetSystemPrompts(chatID int) ([]SystemPrompt, error)
+	SetActiveMessage(chatID int, messageID int) error
+	CreateWorkspaceRoot(root *WorkspaceRoot) error
+	GetWorkspaceRoots(chatID int) ([]WorkspaceRoot, error)
+	DeleteWorkspaceRoot(chatID int, id int) error
+}
 
-	return &Chat{}, nil
+type chatStore struct {
+	db *conn
 }
 
-func (c *Chat) Create(chat Chat) error {
+// Create inserts the chat with its system prompt as version 1 of the history and sets chat.ID
+func (s *chatStore) Create(chat *Chat) error {
 	query := `
-		INSERT INTO chats (uuid, title, description, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO chats (uuid, title, description, provider, model, temperature, max_tokens, system_prompt, system_prompt_version, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
 	`
 
-	now := time.Now()
-	result, err := client.Exec(
-		query,
-		chat.UUID,
-		chat.Title,
-		chat.Description,
-		now,
-		now,
-	)
+	return inTx(s.db, func(tx *conn) error {
+		now := time.Now().UTC()
+		var id int
+		err := tx.QueryRow(
+			query,
+			chat.UUID,
+			chat.Title,
+			chat.Description,
+			chat.Provider,
+			chat.Model,
+			chat.Temperature,
+			chat.MaxTokens,
+			chat.SystemPrompt,
+			1,
+			now,
+			now,
+		).Scan(&id)
 
-	if err != nil {
-		return fmt.Errorf("failed to create chat: %v", err)
-	}
+		if err != nil {
+			return fmt.Errorf("failed to create chat: %v", err)
+		}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return fmt.Errorf("failed to get last insert id: %v", err)
-	}
+		_, err = tx.Exec(`
+			INSERT INTO system_prompts (chat_id, version, content, created_at)
+			VALUES (?, ?, ?, ?)
+		`, id, 1, chat.SystemPrompt, now)
+		if err != nil {
+			return fmt.Errorf("failed to create system prompt: %v", err)
+		}
 
-	chat.ID = int(id)
-	return nil
+		chat.ID = id
+		chat.SystemPromptVersion = 1
+		chat.CreatedAt = now
+		chat.UpdatedAt = now
+		return nil
+	})
 }
 
-func (c *Chat) GetByUUID(uuid string) (*Chat, error) {
+func (s *chatStore) GetByUUID(uuid string) (*Chat, error) {
 	query := `
-		SELECT id, uuid, title, description, created_at, updated_at
+		SELECT ` + chatColumns + `
 		FROM chats
 		WHERE uuid = ?
 	`
 
-	var chat Chat
-	err := client.QueryRow(query, uuid).Scan(
-		&chat.ID,
-		&chat.UUID,
-		&chat.Title,
-		&chat.Description,
-		&chat.CreatedAt,
-		&chat.UpdatedAt,
-	)
-
+	chat, err := scanChat(s.db.QueryRow(query, uuid))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chat by UUID: %v", err)
 	}
 
-	return &chat, nil
+	return chat, nil
 }
 
-func (c *Chat) GetMessagesByChatID(chatID int) ([]Message, error) {
+func (s *chatStore) GetByID(id int) (*Chat, error) {
 	query := `
-		SELECT id, uuid, chat_id, role, content, created_at
-		FROM messages
-		WHERE chat_id = ?
-		ORDER BY created_at ASC
+		SELECT ` + chatColumns + `
+		FROM chats
+		WHERE id = ?
 	`
 
-	rows, err := client.Query(query, chatID)
+	chat, err := scanChat(s.db.QueryRow(query, id))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get messages: %v", err)
+		return nil, fmt.Errorf("failed to get chat by ID: %v", err)
+	}
+
+	return chat, nil
+}
+
+func (s *chatStore) GetAll() ([]Chat, error) {
+	query := `
+		SELECT ` + chatColumns + `
+		FROM chats
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all chats: %v", err)
 	}
 	defer rows.Close()
 
-	var messages []Message
+	var chats []Chat
 	for rows.Next() {
-		var msg Message
-		err := rows.Scan(
-			&msg.ID,
-			&msg.UUID,
-			&msg.ChatID,
-			&msg.Role,
-			&msg.Content,
-			&msg.CreatedAt,
-		)
+		chat, err := scanChat(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan message row: %v", err)
+			return nil, fmt.Errorf("failed to scan chat row: %v", err)
 		}
-		messages = append(messages, msg)
+		chats = append(chats, *chat)
 	}
 
-	return messages, nil
+	return chats, nil
 }
 
-func (m *Message) Create(message Message) error {
-	query := `
-		INSERT INTO messages (uuid, chat_id, role, content, created_at)
-		VALUES (?, ?, ?, ?, ?)
-	`
+const chatColumns = `id, uuid, title, description, provider, model, temperature, max_tokens, system_prompt,
+			system_prompt_version, active_message_id, created_at, updated_at`
 
-	result, err := client.Exec(
-		query,
-		message.UUID,
-		message.ChatID,
-		message.Role,
-		message.Content,
-		time.Now(),
+// scanChat scans a row selected with chatColumns
+func scanChat(row scanner) (*Chat, error) {
+	var chat Chat
+	var activeMessageID sql.NullInt64
+	err := row.Scan(
+		&chat.ID,
+		&chat.UUID,
+		&chat.Title,
+		&chat.Description,
+		&chat.Provider,
+		&chat.Model,
+		&chat.Temperature,
+		&chat.MaxTokens,
+		&chat.SystemPrompt,
+		&chat.SystemPromptVersion,
+		&activeMessageID,
+		&chat.CreatedAt,
+		&chat.UpdatedAt,
 	)
-
 	if err != nil {
-		return fmt.Errorf("failed to create message: %v", err)
+		return nil, err
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return fmt.Errorf("failed to get last insert id: %v", err)
-	}
-
-	message.ID = int(id)
-	return nil
+	chat.ActiveMessageID = int(activeMessageID.Int64)
+	return &chat, nil
 }
 
-func (f *File) Create(file File) error {
+func (s *chatStore) Update(chat *Chat) error {
 	query := `
-		INSERT INTO files (uuid, chat_id, name, path, content_type, size, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		UPDATE chats
+		SET title = ?, provider = ?, model = ?, temperature = ?, max_tokens = ?, updated_at = ?
+		WHERE uuid = ?
 	`
 
-	result, err := client.Exec(
+	now := time.Now().UTC()
+	result, err := s.db.Exec(
 		query,
-		file.UUID,
-		file.ChatID,
-		file.Name,
-		file.Path,
-		file.ContentType,
-		file.Size,
-		time.Now(),
+		chat.Title,
+		chat.Provider,
+		chat.Model,
+		chat.Temperature,
+		chat.MaxTokens,
+		now,
+		chat.UUID,
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return fmt.Errorf("failed to update chat: %v", err)
 	}
 
-	id, err := result.LastInsertId()
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get last insert id: %v", err)
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no chat found with UUID: %s", chat.UUID)
 	}
 
-	file.ID = int(id)
+	chat.UpdatedAt = now
 	return nil
 }
 
-func (t *Tool) Create(tool Tool) error {
+func (s *chatStore) Delete(uuid string) error {
 	query := `
-		INSERT INTO tools (uuid, name, description, schema, created_at)
-		VALUES (?, ?, ?, ?, ?)
+		DELETE FROM chats
+		WHERE uuid = ?
 	`
 
-	result, err := client.Exec(
-		query,
-		tool.UUID,
-		tool.Name,
-		tool.Description,
-		tool.Schema,
-		time.Now(),
-	)
-
+	result, err := s.db.Exec(query, uuid)
 	if err != nil {
-		return fmt.Errorf("failed to create tool: %v", err)
+		return fmt.Errorf("failed to delete chat: %v", err)
 	}
 
-	id, err := result.LastInsertId()
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get last insert id: %v", err)
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no chat found with UUID: %s", uuid)
 	}
 
-	tool.ID = int(id)
 	return nil
 }
 
-func (ct *ChatTool) AddToolToChat(chatID int, toolID int) error {
-	query := `
-		INSERT OR IGNORE INTO chat_tools (chat_id, tool_id, created_at)
-		VALUES (?, ?, ?)
-	`
+// UpdateSystemPrompt stores content as the next system prompt version of the chat
+func (s *chatStore) UpdateSystemPrompt(chat *Chat, content string) error {
+	return inTx(s.db, func(tx *conn) error {
+		now := time.Now().UTC()
+		_, err := tx.Exec(`
+			UPDATE chats
+			SET system_prompt = ?, system_prompt_version = system_prompt_version + 1, updated_at = ?
+			WHERE uuid = ?
+		`, content, now, chat.UUID)
+		if err != nil {
+			return fmt.Errorf("failed to update system prompt: %v", err)
+		}
 
-	_, err := client.Exec(
-		query,
-		chatID,
-		toolID,
-		time.Now(),
-	)
+		var chatID, version int
+		err = tx.QueryRow(`
+			SELECT id, system_prompt_version
+			FROM chats
+			WHERE uuid = ?
+		`, chat.UUID).Scan(&chatID, &version)
+		if err != nil {
+			return fmt.Errorf("failed to get system prompt version: %v", err)
+		}
 
-	if err != nil {
-		return fmt.Errorf("failed to add tool to chat: %v", err)
-	}
+		_, err = tx.Exec(`
+			INSERT INTO system_prompts (chat_id, version, content, created_at)
+			VALUES (?, ?, ?, ?)
+		`, chatID, version, content, now)
+		if err != nil {
+			return fmt.Errorf("failed to create system prompt: %v", err)
+		}
 
-	return nil
+		chat.SystemPrompt = content
+		chat.SystemPromptVersion = version
+		chat.UpdatedAt = now
+		return nil
+	})
 }
 
-func (tc *ToolCall) Create(toolCall ToolCall) error {
+func (s *chatStore) GetSystemPrompts(chatID int) ([]SystemPrompt, error) {
 	query := `
-		INSERT INTO tool_calls (uuid, message_id, tool_id, input, status, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
+		SELECT id, chat_id, version, content, created_at
+		FROM system_prompts
+		WHERE chat_id = ?
+		ORDER BY version DESC
 	`
 
-	result, err := client.Exec(
-		query,
-		toolCall.UUID,
-		toolCall.MessageID,
-		toolCall.ToolID,
-		toolCall.Input,
-		"pending",
-		time.Now(),
-	)
-
+	rows, err := s.db.Query(query, chatID)
 	if err != nil {
-		return fmt.Errorf("failed to create tool call: %v", err)
+		return nil, fmt.Errorf("failed to get system prompts: %v", err)
+	}
+	defer rows.Close()
+
+	var prompts []SystemPrompt
+	for rows.Next() {
+		var prompt SystemPrompt
+		err := rows.Scan(
+			&prompt.ID,
+			&prompt.ChatID,
+			&prompt.Version,
+			&prompt.Content,
+			&prompt.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan system prompt row: %v", err)
+		}
+		prompts = append(prompts, prompt)
 	}
 
-	id, err := result.LastInsertId()
+	return prompts, nil
+}
+
+// SetActiveMessage selects the branch ending at messageID
+func (s *chatStore) SetActiveMessage(chatID int, messageID int) error {
+	query := `
+		UPDATE chats
+		SET active_message_id = ?
+		WHERE id = ?
+	`
+
+	_, err := s.db.Exec(query, messageID, chatID)
 	if err != nil {
-		return fmt.Errorf("failed to get last insert id: %v", err)
+		return fmt.Errorf("failed to set active message: %v", err)
 	}
 
-	toolCall.ID = int(id)
 	return nil
 }
 
-func (tc *ToolCall) UpdateResult(uuid string, output string, status string) error {
+// CreateWorkspaceRoot adds a directory to the chat workspace, or changes its mode and size limit when it is already there
+func (s *chatStore) CreateWorkspaceRoot(root *WorkspaceRoot) error {
 	query := `
-		UPDATE tool_calls
-		SET output = ?, status = ?, finished_at = ?
-		WHERE uuid = ?
+		INSERT INTO workspace_roots (chat_id, path, mode, max_file_size, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chat_id, path) DO UPDATE SET mode = excluded.mode, max_file_size = excluded.max_file_size
 	`
 
-	_, err := client.Exec(
+	_, err := s.db.Exec(
 		query,
-		output,
-		status,
-		time.Now(),
-		uuid,
+		root.ChatID,
+		root.Path,
+		root.Mode,
+		root.MaxFileSize,
+		time.Now().UTC(),
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to update tool call result: %v", err)
+		return fmt.Errorf("failed to create workspace root: %v", err)
+	}
+
+	// The upsert may have updated an existing row, whose ID LastInsertId does not report
+	err = s.db.QueryRow(`
+		SELECT id, created_at
+		FROM workspace_roots
+		WHERE chat_id = ? AND path = ?
+	`, root.ChatID, root.Path).Scan(&root.ID, &root.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to get workspace root: %v", err)
 	}
 
 	return nil
 }
 
-func (c *Chat) GetAll() ([]Chat, error) {
+func (s *chatStore) GetWorkspaceRoots(chatID int) ([]WorkspaceRoot, error) {
 	query := `
-		SELECT id, uuid, title, description, created_at, updated_at
-		FROM chats
-		ORDER BY created_at DESC
+		SELECT id, chat_id, path, mode, max_file_size, created_at
+		FROM workspace_roots
+		WHERE chat_id = ?
+		ORDER BY id ASC
 	`
 
-	rows, err := client.Query(query)
+	rows, err := s.db.Query(query, chatID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get all chats: %v", err)
+		return nil, fmt.Errorf("failed to get workspace roots: %v", err)
 	}
 	defer rows.Close()
 
-	var chats []Chat
+	var roots []WorkspaceRoot
 	for rows.Next() {
-		var chat Chat
+		var root WorkspaceRoot
 		err := rows.Scan(
-			&chat.ID,
-			&chat.UUID,
-			&chat.Title,
-			&chat.Description,
-			&chat.CreatedAt,
-			&chat.UpdatedAt,
+			&root.ID,
+			&root.ChatID,
+			&root.Path,
+			&root.Mode,
+			&root.MaxFileSize,
+			&root.CreatedAt,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan chat row: %v", err)
+			return nil, fmt.Errorf("failed to scan workspace root row: %v", err)
 		}
-		chats = append(chats, chat)
+		roots = append(roots, root)
 	}
 
-	return chats, nil
+	return roots, nil
 }
 
-func (c *Chat) Update() error {
+func (s *chatStore) DeleteWorkspaceRoot(chatID int, id int) error {
 	query := `
-		UPDATE chats
-		SET title = ?, updated_at = ?
-		WHERE uuid = ?
+		DELETE FROM workspace_roots
+		WHERE chat_id = ? AND id = ?
 	`
 
-	now := time.Now()
-	result, err := client.Exec(
-		query,
-		c.Title,
-		now,
-		c.UUID,
-	)
-
-	if err != nil {
-		return fmt.Errorf("failed to update chat: %v", err)
-	}
-
-	rowsAffected, err := result.RowsAffected()
+	result, err := s.db.Exec(query, chatID, id)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %v", err)
-	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("no chat found with UUID: %s", c.UUID)
-	}
-
-	return nil
-}
-
-func (c *Chat) Delete() error {
-	query := `
-		DELETE FROM chats
-		WHERE uuid = ?
-	`
-
-	result, err := client.Exec(query, c.UUID)
-	if err != nil {
-		return fmt.Errorf("failed to delete chat: %v", err)
+		return fmt.Errorf("failed to delete workspace root: %v", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -481,7 +511,7 @@ func (c *Chat) Delete() error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("no chat found with UUID: %s", c.UUID)
+		return fmt.Errorf("no workspace root found with ID: %d", id)
 	}
 
 	return nil
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Supported values of DB_DRIVER
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// conn represents a *sql.DB or *sql.Tx together with its driver. Queries are written for SQLite,
+// with ? placeholders, and rewritten for the driver before they run.
+type conn struct {
+	db     DBTX
+	driver string
+}
+
+func (c *conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.db.Exec(c.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.Query(c.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRow(c.rebind(query), args...)
+}
+
+// rebind numbers the ? placeholders as $1, $2, ... for Postgres
+func (c *conn) rebind(query string) string {
+	if c.driver != DriverPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+var (
+	autoIncrement = regexp.MustCompile(`\bINTEGER PRIMARY KEY AUTOINCREMENT\b`)
+	timestampType = regexp.MustCompile(`\bTIMESTAMP\b`)
+	realType      = regexp.MustCompile(`\bREAL\b`)
+)
+
+// ddl translates a schema statement written for SQLite to the types of the driver
+func (c *conn) ddl(statement string) string {
+	if c.driver != DriverPostgres {
+		return statement
+	}
+
+	statement = autoIncrement.ReplaceAllString(statement, "SERIAL PRIMARY KEY")
+	statement = timestampType.ReplaceAllString(statement, "TIMESTAMPTZ")
+	return realType.ReplaceAllString(statement, "DOUBLE PRECISION")
+}
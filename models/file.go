@@ -0,0 +1,171 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// FileStore represents the persistence of files attached to chats
+type FileStore interface {
+	Create(file *File) error
+	GetByUUID(uuid string) (*File, error)
+	GetByChatID(chatID int) ([]File, error)
+	GetTotalSize(chatID int) (int64, error)
+	AttachToMessage(fileID int, messageID int) error
+	Delete(uuid string) error
+}
+
+type fileStore struct {
+	db *conn
+}
+
+// Create inserts the file and sets file.ID, a file without MessageID waits to be sent with a message
+func (s *fileStore) Create(file *File) error {
+	query := `
+		INSERT INTO files (uuid, chat_id, message_id, name, path, content_type, size, text, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+
+	now := time.Now().UTC()
+	var id int
+	err := s.db.QueryRow(
+		query,
+		file.UUID,
+		file.ChatID,
+		nullInt(file.MessageID),
+		file.Name,
+		file.Path,
+		file.ContentType,
+		file.Size,
+		file.Text,
+		now,
+	).Scan(&id)
+
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+
+	file.ID = id
+	file.CreatedAt = now
+	return nil
+}
+
+func (s *fileStore) GetByUUID(uuid string) (*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE uuid = ?
+	`
+
+	file, err := scanFile(s.db.QueryRow(query, uuid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file by UUID: %v", err)
+	}
+
+	return file, nil
+}
+
+// GetByChatID returns the files of a chat in upload order
+func (s *fileStore) GetByChatID(chatID int) ([]File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE chat_id = ?
+		ORDER BY id ASC
+	`
+
+	rows, err := s.db.Query(query, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files: %v", err)
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file row: %v", err)
+		}
+		files = append(files, *file)
+	}
+
+	return files, nil
+}
+
+// GetTotalSize returns the bytes stored for the files of a chat
+func (s *fileStore) GetTotalSize(chatID int) (int64, error) {
+	var total int64
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM files WHERE chat_id = ?`, chatID).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get total file size: %v", err)
+	}
+
+	return total, nil
+}
+
+// AttachToMessage links a file waiting to be sent to the message that carries it
+func (s *fileStore) AttachToMessage(fileID int, messageID int) error {
+	result, err := s.db.Exec(`UPDATE files SET message_id = ? WHERE id = ? AND message_id IS NULL`, messageID, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to attach file: %v", err)
+	}
+
+	if attached, err := result.RowsAffected(); err == nil && attached == 0 {
+		return fmt.Errorf("failed to attach file: already attached to a message")
+	}
+
+	return nil
+}
+
+func (s *fileStore) Delete(uuid string) error {
+	query := `
+		DELETE FROM files
+		WHERE uuid = ?
+	`
+
+	result, err := s.db.Exec(query, uuid)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no file found with UUID: %s", uuid)
+	}
+
+	return nil
+}
+
+// fileColumns leaves out the text, it is only read by search
+const fileColumns = `id, uuid, chat_id, message_id, name, path, content_type, size, created_at`
+
+// scanFile scans a row selected with fileColumns
+func scanFile(row scanner) (*File, error) {
+	var file File
+	var messageID sql.NullInt64
+	var contentType sql.NullString
+	err := row.Scan(
+		&file.ID,
+		&file.UUID,
+		&file.ChatID,
+		&messageID,
+		&file.Name,
+		&file.Path,
+		&contentType,
+		&file.Size,
+		&file.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	file.MessageID = int(messageID.Int64)
+	file.ContentType = contentType.String
+	return &file, nil
+}
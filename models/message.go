@@ -0,0 +1,256 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MessageStore represents the persistence of the message tree of chats
+type MessageStore interface {
+	Create(message *Message) error
+	GetByUUID(uuid string) (*Message, error)
+	GetByID(id int) (*Message, error)
+	GetActivePath(chatID int) ([]Message, error)
+	GetTree(chatID int) ([]Message, error)
+	GetSiblings(message Message) ([]Message, error)
+	GetLatestLeaf(messageID int) (int, error)
+	Update(message *Message) error
+	FailPending() error
+}
+
+type messageStore struct {
+	db *conn
+}
+
+// Create inserts the message, makes it the leaf of the active branch of its chat and sets message.ID
+func (s *messageStore) Create(message *Message) error {
+	query := `
+		INSERT INTO messages (uuid, chat_id, parent_id, role, content, system_prompt_version, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+
+	if message.Status == "" {
+		message.Status = MessageCompleted
+	}
+
+	return inTx(s.db, func(tx *conn) error {
+		now := time.Now().UTC()
+		var id int
+		err := tx.QueryRow(
+			query,
+			message.UUID,
+			message.ChatID,
+			nullInt(message.ParentID),
+			message.Role,
+			message.Content,
+			message.SystemPromptVersion,
+			message.Status,
+			now,
+		).Scan(&id)
+
+		if err != nil {
+			return fmt.Errorf("failed to create message: %v", err)
+		}
+
+		_, err = tx.Exec(`UPDATE chats SET active_message_id = ? WHERE id = ?`, id, message.ChatID)
+		if err != nil {
+			return fmt.Errorf("failed to set active message: %v", err)
+		}
+
+		message.ID = id
+		message.CreatedAt = now
+		return nil
+	})
+}
+
+func (s *messageStore) GetByUUID(uuid string) (*Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		WHERE m.uuid = ?
+	`
+
+	message, err := scanMessage(s.db.QueryRow(query, uuid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message by UUID: %v", err)
+	}
+
+	return message, nil
+}
+
+func (s *messageStore) GetByID(id int) (*Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		WHERE m.id = ?
+	`
+
+	message, err := scanMessage(s.db.QueryRow(query, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message by ID: %v", err)
+	}
+
+	return message, nil
+}
+
+// GetActivePath returns the active branch of a chat, from the first message to the selected leaf
+func (s *messageStore) GetActivePath(chatID int) ([]Message, error) {
+	query := `
+		WITH RECURSIVE path(id, depth) AS (
+			SELECT active_message_id, 0 FROM chats WHERE id = ? AND active_message_id IS NOT NULL
+			UNION ALL
+			SELECT m.parent_id, p.depth + 1 FROM messages m JOIN path p ON m.id = p.id WHERE m.parent_id IS NOT NULL
+		)
+		SELECT ` + messageColumns + `
+		FROM path p
+		JOIN messages m ON m.id = p.id
+		ORDER BY p.depth DESC
+	`
+
+	return s.query(query, chatID)
+}
+
+// GetTree returns every message of a chat across all branches, oldest first
+func (s *messageStore) GetTree(chatID int) ([]Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		WHERE m.chat_id = ?
+		ORDER BY m.created_at ASC, m.id ASC
+	`
+
+	return s.query(query, chatID)
+}
+
+// GetSiblings returns the alternatives of a message, itself included, oldest first
+func (s *messageStore) GetSiblings(message Message) ([]Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		WHERE m.chat_id = ? AND m.parent_id = ?
+		ORDER BY m.created_at ASC, m.id ASC
+	`
+	args := []any{message.ChatID, message.ParentID}
+
+	// The first messages of a chat have no parent to compare
+	if message.ParentID == 0 {
+		query = `
+			SELECT ` + messageColumns + `
+			FROM messages m
+			WHERE m.chat_id = ? AND m.parent_id IS NULL
+			ORDER BY m.created_at ASC, m.id ASC
+		`
+		args = args[:1]
+	}
+
+	return s.query(query, args...)
+}
+
+// GetLatestLeaf follows the most recent reply of each message down from messageID and returns the
+// last one, which selects the newest branch below a message
+func (s *messageStore) GetLatestLeaf(messageID int) (int, error) {
+	query := `
+		SELECT id
+		FROM messages
+		WHERE parent_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`
+
+	for {
+		var childID int
+		err := s.db.QueryRow(query, messageID).Scan(&childID)
+		if err == sql.ErrNoRows {
+			return messageID, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to get latest reply: %v", err)
+		}
+		messageID = childID
+	}
+}
+
+// Update saves the content and status of a message
+func (s *messageStore) Update(message *Message) error {
+	query := `
+		UPDATE messages
+		SET content = ?, status = ?
+		WHERE uuid = ?
+	`
+
+	if message.Status == "" {
+		message.Status = MessageCompleted
+	}
+
+	_, err := s.db.Exec(query, message.Content, message.Status, message.UUID)
+	if err != nil {
+		return fmt.Errorf("failed to update message: %v", err)
+	}
+
+	return nil
+}
+
+// FailPending marks the messages left pending by a previous process as failed, their generation is gone
+func (s *messageStore) FailPending() error {
+	query := `
+		UPDATE messages
+		SET status = ?
+		WHERE status = ?
+	`
+
+	_, err := s.db.Exec(query, MessageFailed, MessagePending)
+	if err != nil {
+		return fmt.Errorf("failed to fail pending messages: %v", err)
+	}
+
+	return nil
+}
+
+const messageColumns = `m.id, m.uuid, m.chat_id, m.parent_id, m.role, m.content, m.system_prompt_version, m.status, m.created_at`
+
+func (s *messageStore) query(query string, args ...any) ([]Message, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %v", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message row: %v", err)
+		}
+		messages = append(messages, *msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read message rows: %v", err)
+	}
+
+	return messages, nil
+}
+
+// scanMessage scans a row selected with messageColumns
+func scanMessage(row scanner) (*Message, error) {
+	var msg Message
+	var parentID sql.NullInt64
+	err := row.Scan(
+		&msg.ID,
+		&msg.UUID,
+		&msg.ChatID,
+		&parentID,
+		&msg.Role,
+		&msg.Content,
+		&msg.SystemPromptVersion,
+		&msg.Status,
+		&msg.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.ParentID = int(parentID.Int64)
+	return &msg, nil
+}
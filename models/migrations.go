@@ -0,0 +1,494 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// migration represents a numbered schema change, applied once and recorded in schema_migrations
+type migration struct {
+	version int
+	name    string
+	up      func(tx *conn) error
+}
+
+// MigrationState represents a migration and when it was applied, AppliedAt is zero while pending
+type MigrationState struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// migrations must only ever be appended to. Steps use IF NOT EXISTS and addColumn so databases
+// created before versioning, by any earlier build, converge on the same schema.
+var migrations = []migration{
+	{1, "initial_schema", func(tx *conn) error {
+		return execAll(tx,
+			`CREATE TABLE IF NOT EXISTS chats (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				uuid TEXT UNIQUE,
+				title TEXT,
+				description TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS messages (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				uuid TEXT UNIQUE,
+				chat_id INTEGER,
+				role TEXT NOT NULL,
+				content TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS files (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				uuid TEXT UNIQUE,
+				chat_id INTEGER,
+				name TEXT NOT NULL,
+				path TEXT NOT NULL,
+				content_type TEXT,
+				size INTEGER,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS tools (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				uuid TEXT UNIQUE,
+				name TEXT NOT NULL,
+				description TEXT,
+				schema TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_tools (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				chat_id INTEGER,
+				tool_id INTEGER,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(chat_id, tool_id),
+				FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+				FOREIGN KEY (tool_id) REFERENCES tools(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS tool_calls (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				uuid TEXT UNIQUE,
+				message_id INTEGER,
+				tool_id INTEGER,
+				input TEXT,
+				output TEXT,
+				status TEXT DEFAULT 'pending',
+				started_at TIMESTAMP,
+				finished_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+				FOREIGN KEY (tool_id) REFERENCES tools(id) ON DELETE CASCADE
+			)`,
+		)
+	}},
+	{2, "chat_generation_settings", func(tx *conn) error {
+		return addColumns(tx, "chats", map[string]string{
+			"provider":    "TEXT NOT NULL DEFAULT 'anthropic'",
+			"model":       "TEXT NOT NULL DEFAULT ''",
+			"temperature": "REAL NOT NULL DEFAULT 0.7",
+			"max_tokens":  "INTEGER NOT NULL DEFAULT 4096",
+		})
+	}},
+	{3, "system_prompts", func(tx *conn) error {
+		if err := addColumns(tx, "chats", map[string]string{
+			"system_prompt":         "TEXT NOT NULL DEFAULT ''",
+			"system_prompt_version": "INTEGER NOT NULL DEFAULT 1",
+		}); err != nil {
+			return err
+		}
+		if err := addColumns(tx, "messages", map[string]string{
+			"system_prompt_version": "INTEGER NOT NULL DEFAULT 0",
+		}); err != nil {
+			return err
+		}
+
+		return execAll(tx,
+			`CREATE TABLE IF NOT EXISTS system_prompts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				chat_id INTEGER,
+				version INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(chat_id, version),
+				FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+			)`,
+		)
+	}},
+	{4, "workspace_roots", func(tx *conn) error {
+		return execAll(tx,
+			`CREATE TABLE IF NOT EXISTS workspace_roots (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				chat_id INTEGER,
+				path TEXT NOT NULL,
+				mode TEXT NOT NULL DEFAULT 'read',
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(chat_id, path),
+				FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+			)`,
+		)
+	}},
+	{5, "tool_call_approvals", func(tx *conn) error {
+		return addColumns(tx, "tool_calls", map[string]string{
+			"approval":            "TEXT NOT NULL DEFAULT ''",
+			"preview":             "TEXT NOT NULL DEFAULT ''",
+			"approval_expires_at": "TIMESTAMP",
+		})
+	}},
+	{6, "message_status", func(tx *conn) error {
+		return addColumns(tx, "messages", map[string]string{
+			"status": "TEXT NOT NULL DEFAULT 'completed'",
+		})
+	}},
+	{7, "message_branches", func(tx *conn) error {
+		if err := addColumns(tx, "messages", map[string]string{
+			"parent_id": "INTEGER REFERENCES messages(id) ON DELETE CASCADE",
+		}); err != nil {
+			return err
+		}
+		if err := addColumns(tx, "chats", map[string]string{
+			"active_message_id": "INTEGER",
+		}); err != nil {
+			return err
+		}
+
+		// Chain the flat history of older chats by creation time and select their latest message
+		return execAll(tx,
+			`UPDATE messages
+			SET parent_id = (
+				SELECT p.id FROM messages p
+				WHERE p.chat_id = messages.chat_id
+					AND (p.created_at < messages.created_at OR (p.created_at = messages.created_at AND p.id < messages.id))
+				ORDER BY p.created_at DESC, p.id DESC
+				LIMIT 1
+			)
+			WHERE chat_id IN (SELECT id FROM chats WHERE active_message_id IS NULL)`,
+			`UPDATE chats
+			SET active_message_id = (
+				SELECT m.id FROM messages m
+				WHERE m.chat_id = chats.id
+				ORDER BY m.created_at DESC, m.id DESC
+				LIMIT 1
+			)
+			WHERE active_message_id IS NULL`,
+		)
+	}},
+	{8, "search_index", func(tx *conn) error {
+		// Postgres has no FTS5, search scans the tables there
+		if tx.driver != DriverSQLite {
+			return nil
+		}
+
+		// Stay pending on SQLite built without -tags sqlite_fts5, until a build that has it starts
+		available, err := fts5Available(tx)
+		if err != nil {
+			return err
+		}
+		if !available {
+			return errMigrationSkipped
+		}
+
+		// External content tables index the rows in place, the triggers keep them in sync
+		return execAll(tx,
+			`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+				content,
+				content='messages',
+				content_rowid='id',
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+				INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+				INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+				INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+				INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+			END`,
+			`CREATE VIRTUAL TABLE IF NOT EXISTS chats_fts USING fts5(
+				title,
+				description,
+				content='chats',
+				content_rowid='id',
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS chats_fts_insert AFTER INSERT ON chats BEGIN
+				INSERT INTO chats_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS chats_fts_delete AFTER DELETE ON chats BEGIN
+				INSERT INTO chats_fts (chats_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS chats_fts_update AFTER UPDATE OF title, description ON chats BEGIN
+				INSERT INTO chats_fts (chats_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+				INSERT INTO chats_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+			END`,
+			`INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')`,
+			`INSERT INTO chats_fts (chats_fts) VALUES ('rebuild')`,
+		)
+	}},
+	{9, "file_messages", func(tx *conn) error {
+		if err := addColumns(tx, "files", map[string]string{
+			"message_id": "INTEGER REFERENCES messages(id) ON DELETE CASCADE",
+		}); err != nil {
+			return err
+		}
+
+		return execAll(tx, `CREATE INDEX IF NOT EXISTS idx_files_message_id ON files (message_id)`)
+	}},
+	{10, "file_text", func(tx *conn) error {
+		return addColumns(tx, "files", map[string]string{
+			"text": "TEXT",
+		})
+	}},
+	{11, "file_search_index", func(tx *conn) error {
+		// Like search_index, Postgres scans the table and SQLite waits for a build with FTS5
+		if tx.driver != DriverSQLite {
+			return nil
+		}
+
+		available, err := fts5Available(tx)
+		if err != nil {
+			return err
+		}
+		if !available {
+			return errMigrationSkipped
+		}
+
+		return execAll(tx,
+			`CREATE VIRTUAL TABLE IF NOT EXISTS files_fts USING fts5(
+				name,
+				text,
+				content='files',
+				content_rowid='id',
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS files_fts_insert AFTER INSERT ON files BEGIN
+				INSERT INTO files_fts (rowid, name, text) VALUES (new.id, new.name, new.text);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS files_fts_delete AFTER DELETE ON files BEGIN
+				INSERT INTO files_fts (files_fts, rowid, name, text) VALUES ('delete', old.id, old.name, old.text);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS files_fts_update AFTER UPDATE OF name, text ON files BEGIN
+				INSERT INTO files_fts (files_fts, rowid, name, text) VALUES ('delete', old.id, old.name, old.text);
+				INSERT INTO files_fts (rowid, name, text) VALUES (new.id, new.name, new.text);
+			END`,
+			`INSERT INTO files_fts (files_fts) VALUES ('rebuild')`,
+		)
+	}},
+	{12, "utc_timestamps", func(tx *conn) error {
+		// Stores wrote local times while CURRENT_TIMESTAMP writes UTC, so rows mix both
+		return utcTimes(tx, map[string][]string{
+			"chats":             {"created_at", "updated_at"},
+			"system_prompts":    {"created_at"},
+			"messages":          {"created_at"},
+			"files":             {"created_at"},
+			"tools":             {"created_at"},
+			"chat_tools":        {"created_at"},
+			"tool_calls":        {"approval_expires_at", "started_at", "finished_at", "created_at"},
+			"workspace_roots":   {"created_at"},
+			"schema_migrations": {"applied_at"},
+		})
+	}},
+	{13, "workspace_max_file_size", func(tx *conn) error {
+		// Zero leaves the root on the TOOLS_MAX_FILE_SIZE limit
+		return addColumns(tx, "workspace_roots", map[string]string{
+			"max_file_size": "INTEGER NOT NULL DEFAULT 0",
+		})
+	}},
+}
+
+// errMigrationSkipped leaves a migration pending without failing the others
+var errMigrationSkipped = errors.New("migration skipped")
+
+// Migrate applies the pending migrations in order, each in its own transaction
+func Migrate() error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		err := applyMigration(m)
+		if errors.Is(err, errMigrationSkipped) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d %s: %v", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrationStatus lists every known migration with the time it was applied
+func MigrationStatus() ([]MigrationState, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		states = append(states, MigrationState{
+			Version:   m.version,
+			Name:      m.name,
+			AppliedAt: applied[m.version],
+		})
+	}
+
+	return states, nil
+}
+
+func applyMigration(m migration) error {
+	return inTx(client, func(tx *conn) error {
+		if err := m.up(tx); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO schema_migrations (version, name, applied_at)
+			VALUES (?, ?, ?)
+		`, m.version, m.name, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("failed to record migration: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// appliedMigrations returns the applied migration versions with the time they were applied
+func appliedMigrations() (map[int]time.Time, error) {
+	_, err := client.Exec(client.ddl(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	rows, err := client.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan migration row: %v", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// execAll runs statements written for SQLite in the dialect of tx
+func execAll(tx *conn, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(tx.ddl(statement)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// utcTimes rewrites the times stored in the columns of each table in UTC, in the format the SQLite driver
+// writes them. Times then compare as text whether they came from Go, written in the local zone by earlier
+// builds, or from CURRENT_TIMESTAMP. Postgres stores TIMESTAMPTZ, which compares as instants.
+func utcTimes(tx *conn, tables map[string][]string) error {
+	if tx.driver != DriverSQLite {
+		return nil
+	}
+
+	// Map order is random, sort for a deterministic migration
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, table := range names {
+		for _, column := range tables[table] {
+			// Times SQLite cannot read are left as they are
+			utc := fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:%%M:%%f', %s) || '+00:00'", column)
+			_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s IS NOT NULL", table, column, utc, utc))
+			if err != nil {
+				return fmt.Errorf("failed to convert %s.%s to UTC: %v", table, column, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// addColumns adds the columns a table does not have yet
+func addColumns(tx *conn, table string, columns map[string]string) error {
+	existing, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+
+	// Map order is random, sort for a deterministic schema
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if existing[name] {
+			continue
+		}
+		if _, err := tx.Exec(tx.ddl(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, columns[name]))); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %v", table, name, err)
+		}
+	}
+
+	return nil
+}
+
+// tableColumns returns the names of the columns of table
+func tableColumns(tx *conn, table string) (map[string]bool, error) {
+	query := fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table)
+	if tx.driver == DriverPostgres {
+		query = fmt.Sprintf(`
+			SELECT column_name
+			FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = '%s'
+		`, table)
+	}
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan columns of %s: %v", table, err)
+		}
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestUTCTimes(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx := &conn{db: db, driver: DriverSQLite}
+	if _, err := tx.Exec(`CREATE TABLE events (id INTEGER PRIMARY KEY, at TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		stored any
+		want   sql.NullString
+	}{
+		{nil, sql.NullString{}},
+		{"2024-03-01 12:30:00.123456789+02:00", sql.NullString{String: "2024-03-01 10:30:00.123+00:00", Valid: true}},
+		{"2024-03-01 10:30:00", sql.NullString{String: "2024-03-01 10:30:00.000+00:00", Valid: true}},
+		{"2024-03-01 10:30:00.123+00:00", sql.NullString{String: "2024-03-01 10:30:00.123+00:00", Valid: true}},
+		{"not a time", sql.NullString{String: "not a time", Valid: true}},
+	}
+	for i, tt := range tests {
+		if _, err := tx.Exec(`INSERT INTO events (id, at) VALUES (?, ?)`, i, tt.stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := utcTimes(tx, map[string][]string{"events": {"at"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range tests {
+		var got sql.NullString
+		if err := tx.QueryRow(`SELECT at FROM events WHERE id = ?`, i).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%v = %v, want %v", tt.stored, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,564 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search hit kinds
+const (
+	SearchHitChat    = "chat"
+	SearchHitMessage = "message"
+	SearchHitFile    = "file"
+)
+
+// SearchQuery represents a full-text search, the zero values of the filters match everything
+type SearchQuery struct {
+	Text  string
+	Role  string    // only messages with this role, chats and files are left out
+	From  time.Time // created at or after
+	To    time.Time // created before
+	Limit int
+}
+
+// SearchHit represents a chat title or description, a message, or the name or text of a file, matching a search
+type SearchHit struct {
+	Kind        string    `json:"kind"` // chat, message, file
+	ChatUUID    string    `json:"chat_uuid"`
+	ChatTitle   string    `json:"chat_title"`
+	MessageUUID string    `json:"message_uuid,omitempty"` // for files, the message that carried it once sent
+	FileUUID    string    `json:"file_uuid,omitempty"`
+	FileName    string    `json:"file_name,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Snippet     string    `json:"snippet"`
+	Highlights  [][2]int  `json:"highlights"` // [start, end) character offsets of the matches in Snippet
+	Rank        float64   `json:"rank"`       // lower ranks better
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SearchStore represents the full-text search over chats, messages and files
+type SearchStore interface {
+	Search(query SearchQuery) ([]SearchHit, error)
+}
+
+type searchStore struct {
+	db *conn
+}
+
+// Snippets mark matches with these control characters, which are stripped into SearchHit.Highlights
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+// snippetTokens is the length of FTS5 snippets, snippetRunes the context kept around the first match
+// when scanning without the index
+const (
+	snippetTokens = 24
+	snippetRunes  = 80
+)
+
+// searchFunc returns the hits of one kind, best first
+type searchFunc func(terms []string, query SearchQuery) ([]SearchHit, error)
+
+// Search returns the best hits first, ranked by bm25 with the FTS5 index and newest first without it
+func (s *searchStore) Search(query SearchQuery) ([]SearchHit, error) {
+	terms := strings.Fields(query.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// Ranks of indexed and scanned hits do not mix, the index is used once every table has one
+	missing, err := missingIndexes(s.db)
+	if err != nil {
+		return nil, err
+	}
+	indexed := len(missing) == 0
+
+	var hits []SearchHit
+	search := s.scanMessages
+	if indexed {
+		search = s.matchMessages
+	}
+	if hits, err = search(terms, query); err != nil {
+		return nil, err
+	}
+
+	// Chats and files carry no role, a role filter only asks for messages
+	if query.Role == "" {
+		searches := []searchFunc{s.scanChats, s.scanFiles}
+		if indexed {
+			searches = []searchFunc{s.matchChats, s.matchFiles}
+		}
+		for _, search := range searches {
+			more, err := search(terms, query)
+			if err != nil {
+				return nil, err
+			}
+			hits = mergeHits(hits, more, indexed)
+		}
+	}
+
+	if len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+
+	return hits, nil
+}
+
+// matchMessages searches the messages_fts index
+func (s *searchStore) matchMessages(terms []string, query SearchQuery) ([]SearchHit, error) {
+	where, args := searchFilters("m", query, true)
+	args = append([]any{matchExpression(terms)}, args...)
+	args = append(args, query.Limit)
+
+	rows, err := s.db.Query(`
+		SELECT c.uuid, c.title, m.uuid, m.role,
+			snippet(messages_fts, 0, char(2), char(3), '…', `+fmt.Sprint(snippetTokens)+`),
+			bm25(messages_fts), m.created_at
+		FROM messages_fts
+		JOIN messages m ON m.id = messages_fts.rowid
+		JOIN chats c ON c.id = m.chat_id
+		WHERE messages_fts MATCH ?`+where+`
+		ORDER BY bm25(messages_fts)
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %v", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		hit := SearchHit{Kind: SearchHitMessage}
+		var snippet string
+		err := rows.Scan(&hit.ChatUUID, &hit.ChatTitle, &hit.MessageUUID, &hit.Role, &snippet, &hit.Rank, &hit.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %v", err)
+		}
+		hit.Snippet, hit.Highlights = highlights(snippet)
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// matchChats searches the chats_fts index, the snippet comes from the best matching column
+func (s *searchStore) matchChats(terms []string, query SearchQuery) ([]SearchHit, error) {
+	where, args := searchFilters("c", query, false)
+	args = append([]any{matchExpression(terms)}, args...)
+	args = append(args, query.Limit)
+
+	rows, err := s.db.Query(`
+		SELECT c.uuid, c.title,
+			snippet(chats_fts, -1, char(2), char(3), '…', `+fmt.Sprint(snippetTokens)+`),
+			bm25(chats_fts), c.created_at
+		FROM chats_fts
+		JOIN chats c ON c.id = chats_fts.rowid
+		WHERE chats_fts MATCH ?`+where+`
+		ORDER BY bm25(chats_fts)
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chats: %v", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		hit := SearchHit{Kind: SearchHitChat}
+		var snippet string
+		err := rows.Scan(&hit.ChatUUID, &hit.ChatTitle, &snippet, &hit.Rank, &hit.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %v", err)
+		}
+		hit.Snippet, hit.Highlights = highlights(snippet)
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// matchFiles searches the files_fts index, the snippet comes from the name or the text
+func (s *searchStore) matchFiles(terms []string, query SearchQuery) ([]SearchHit, error) {
+	where, args := searchFilters("f", query, false)
+	args = append([]any{matchExpression(terms)}, args...)
+	args = append(args, query.Limit)
+
+	rows, err := s.db.Query(`
+		SELECT c.uuid, c.title, COALESCE(m.uuid, ''), f.uuid, f.name,
+			snippet(files_fts, -1, char(2), char(3), '…', `+fmt.Sprint(snippetTokens)+`),
+			bm25(files_fts), f.created_at
+		FROM files_fts
+		JOIN files f ON f.id = files_fts.rowid
+		JOIN chats c ON c.id = f.chat_id
+		LEFT JOIN messages m ON m.id = f.message_id
+		WHERE files_fts MATCH ?`+where+`
+		ORDER BY bm25(files_fts)
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search files: %v", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		hit := SearchHit{Kind: SearchHitFile}
+		var snippet string
+		err := rows.Scan(&hit.ChatUUID, &hit.ChatTitle, &hit.MessageUUID, &hit.FileUUID, &hit.FileName, &snippet, &hit.Rank, &hit.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %v", err)
+		}
+		hit.Snippet, hit.Highlights = highlights(snippet)
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// scanMessages finds the messages containing every term without an index
+func (s *searchStore) scanMessages(terms []string, query SearchQuery) ([]SearchHit, error) {
+	where, args := searchFilters("m", query, true)
+	like, likeArgs := likeAll(terms, "m.content")
+	args = append(likeArgs, args...)
+	args = append(args, query.Limit)
+
+	rows, err := s.db.Query(`
+		SELECT c.uuid, c.title, m.uuid, m.role, m.content, m.created_at
+		FROM messages m
+		JOIN chats c ON c.id = m.chat_id
+		WHERE `+like+where+`
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %v", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		hit := SearchHit{Kind: SearchHitMessage}
+		var content string
+		err := rows.Scan(&hit.ChatUUID, &hit.ChatTitle, &hit.MessageUUID, &hit.Role, &content, &hit.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %v", err)
+		}
+		hit.Snippet, hit.Highlights = highlights(markTerms(content, terms))
+		hit.Rank = float64(len(hits))
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// scanChats finds the chats whose title and description contain every term without an index
+func (s *searchStore) scanChats(terms []string, query SearchQuery) ([]SearchHit, error) {
+	where, args := searchFilters("c", query, false)
+	like, likeArgs := likeAll(terms, "c.title || ' ' || COALESCE(c.description, '')")
+	args = append(likeArgs, args...)
+	args = append(args, query.Limit)
+
+	rows, err := s.db.Query(`
+		SELECT c.uuid, c.title, COALESCE(c.description, ''), c.created_at
+		FROM chats c
+		WHERE `+like+where+`
+		ORDER BY c.created_at DESC, c.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chats: %v", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		hit := SearchHit{Kind: SearchHitChat}
+		var description string
+		err := rows.Scan(&hit.ChatUUID, &hit.ChatTitle, &description, &hit.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %v", err)
+		}
+		hit.Snippet, hit.Highlights = highlights(markTerms(hit.ChatTitle+" — "+description, terms))
+		hit.Rank = float64(len(hits))
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// scanFiles finds the files whose name and text contain every term without an index
+func (s *searchStore) scanFiles(terms []string, query SearchQuery) ([]SearchHit, error) {
+	where, args := searchFilters("f", query, false)
+	like, likeArgs := likeAll(terms, "f.name || ' ' || COALESCE(f.text, '')")
+	args = append(likeArgs, args...)
+	args = append(args, query.Limit)
+
+	rows, err := s.db.Query(`
+		SELECT c.uuid, c.title, COALESCE(m.uuid, ''), f.uuid, f.name, COALESCE(f.text, ''), f.created_at
+		FROM files f
+		JOIN chats c ON c.id = f.chat_id
+		LEFT JOIN messages m ON m.id = f.message_id
+		WHERE `+like+where+`
+		ORDER BY f.created_at DESC, f.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search files: %v", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		hit := SearchHit{Kind: SearchHitFile}
+		var text string
+		err := rows.Scan(&hit.ChatUUID, &hit.ChatTitle, &hit.MessageUUID, &hit.FileUUID, &hit.FileName, &text, &hit.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %v", err)
+		}
+		hit.Snippet, hit.Highlights = highlights(markTerms(hit.FileName+" — "+text, terms))
+		hit.Rank = float64(len(hits))
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// searchFilters returns the conditions on the table aliased alias, starting with AND, and their arguments.
+// Assistant messages still being generated are left out.
+func searchFilters(alias string, query SearchQuery, messages bool) (string, []any) {
+	var where strings.Builder
+	var args []any
+
+	if messages {
+		where.WriteString(" AND " + alias + ".status != '" + MessagePending + "'")
+		if query.Role != "" {
+			where.WriteString(" AND " + alias + ".role = ?")
+			args = append(args, query.Role)
+		}
+	}
+	// SQLite compares times as text, rows are stored in UTC so the bounds are too
+	if !query.From.IsZero() {
+		where.WriteString(" AND " + alias + ".created_at >= ?")
+		args = append(args, query.From.UTC())
+	}
+	if !query.To.IsZero() {
+		where.WriteString(" AND " + alias + ".created_at < ?")
+		args = append(args, query.To.UTC())
+	}
+
+	return where.String(), args
+}
+
+// matchExpression quotes every term so FTS5 syntax in the user input is taken literally,
+// the last term also matches as a prefix for search as you type
+func matchExpression(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	quoted[len(quoted)-1] += "*"
+
+	return strings.Join(quoted, " ")
+}
+
+// likeAll returns a condition requiring every term in column, case insensitively
+func likeAll(terms []string, column string) (string, []any) {
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	conditions := make([]string, len(terms))
+	args := make([]any, len(terms))
+	for i, term := range terms {
+		conditions[i] = "LOWER(" + column + `) LIKE ? ESCAPE '\'`
+		args[i] = "%" + escape.Replace(strings.ToLower(term)) + "%"
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// markTerms cuts a snippet around the first term found in text and marks every term inside it,
+// like the FTS5 snippet function does
+func markTerms(text string, terms []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// Lowercasing changed the length, match on the text as is
+		lower = runes
+	}
+
+	needles := make([][]rune, 0, len(terms))
+	for _, term := range terms {
+		needles = append(needles, []rune(strings.ToLower(term)))
+	}
+
+	first := -1
+	for _, needle := range needles {
+		for i := range lower {
+			if hasPrefixRunes(lower[i:], needle) {
+				if first < 0 || i < first {
+					first = i
+				}
+				break
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if first > snippetRunes {
+		start = first - snippetRunes
+	}
+	if end-start > 3*snippetRunes {
+		end = start + 3*snippetRunes
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		matched := 0
+		for _, needle := range needles {
+			if len(needle) > matched && hasPrefixRunes(lower[i:end], needle) {
+				matched = len(needle)
+			}
+		}
+		if matched == 0 {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+		b.WriteString(highlightStart + string(runes[i:i+matched]) + highlightEnd)
+		i += matched
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return strings.TrimFunc(b.String(), unicode.IsSpace)
+}
+
+func hasPrefixRunes(runes []rune, prefix []rune) bool {
+	if len(prefix) == 0 || len(prefix) > len(runes) {
+		return false
+	}
+
+	for i := range prefix {
+		if runes[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// highlights strips the match markers from a snippet and returns where they were, in characters
+func highlights(marked string) (string, [][2]int) {
+	var b strings.Builder
+	ranges := [][2]int{}
+
+	offset, start := 0, -1
+	for _, r := range marked {
+		switch string(r) {
+		case highlightStart:
+			start = offset
+		case highlightEnd:
+			if start >= 0 && offset > start {
+				ranges = append(ranges, [2]int{start, offset})
+			}
+			start = -1
+		default:
+			b.WriteRune(r)
+			offset++
+		}
+	}
+
+	return b.String(), ranges
+}
+
+// mergeHits interleaves two lists of hits by rank, both are sorted already. Without the index ranks
+// are positions, which interleaves the newest of both.
+func mergeHits(hits []SearchHit, more []SearchHit, indexed bool) []SearchHit {
+	merged := make([]SearchHit, 0, len(hits)+len(more))
+	for len(hits) > 0 && len(more) > 0 {
+		takeMore := more[0].Rank < hits[0].Rank
+		if !indexed {
+			takeMore = more[0].CreatedAt.After(hits[0].CreatedAt)
+		}
+		if takeMore {
+			merged = append(merged, more[0])
+			more = more[1:]
+		} else {
+			merged = append(merged, hits[0])
+			hits = hits[1:]
+		}
+	}
+	merged = append(merged, hits...)
+	merged = append(merged, more...)
+
+	// Renumber the positional ranks after interleaving
+	if !indexed {
+		for i := range merged {
+			merged[i].Rank = float64(i)
+		}
+	}
+
+	return merged
+}
+
+// searchIndexes are the FTS5 tables of the search index
+var searchIndexes = []string{"messages_fts", "chats_fts", "files_fts"}
+
+// MissingSearchIndexes returns the FTS5 tables of the search index the SQLite database lacks, search scans
+// the tables without them. They are created by migrations that wait for a build with the sqlite_fts5 tag.
+// Postgres always scans, nothing is reported missing.
+func MissingSearchIndexes() ([]string, error) {
+	if client.driver != DriverSQLite {
+		return nil, nil
+	}
+
+	return missingIndexes(client)
+}
+
+// missingIndexes returns the searchIndexes db lacks, all of them on drivers other than SQLite
+func missingIndexes(db *conn) ([]string, error) {
+	var missing []string
+	for _, table := range searchIndexes {
+		exists, err := tableExists(db, table)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, table)
+		}
+	}
+
+	return missing, nil
+}
+
+// fts5Available reports whether SQLite was built with FTS5
+func fts5Available(db *conn) (bool, error) {
+	var used bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&used); err != nil {
+		return false, fmt.Errorf("failed to check for FTS5: %v", err)
+	}
+
+	return used, nil
+}
+
+// tableExists reports whether the SQLite database has the table, always false on other drivers
+func tableExists(db *conn, table string) (bool, error) {
+	if db.driver != DriverSQLite {
+		return false, nil
+	}
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for table %s: %v", table, err)
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DBTX represents what the stores run their queries on, a *sql.DB or a *sql.Tx
+type DBTX interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Store represents the repositories of one connection or transaction
+type Store struct {
+	Chats    ChatStore
+	Messages MessageStore
+	Files    FileStore
+	Tools    ToolStore
+	Search   SearchStore
+
+	db *conn
+}
+
+var store *Store
+
+// NewStore returns the SQL repositories running on db, driver is DriverSQLite or DriverPostgres
+func NewStore(db *sql.DB, driver string) *Store {
+	return newStore(&conn{db: db, driver: driver})
+}
+
+func newStore(db *conn) *Store {
+	return &Store{
+		Chats:    &chatStore{db: db},
+		Messages: &messageStore{db: db},
+		Files:    &fileStore{db: db},
+		Tools:    &toolStore{db: db},
+		Search:   &searchStore{db: db},
+		db:       db,
+	}
+}
+
+// Default returns the store of the database opened by Connect
+func Default() *Store {
+	return store
+}
+
+// SetDefault replaces the store returned by Default, tests use it to install fakes
+func SetDefault(s *Store) {
+	store = s
+}
+
+// Transaction runs fn with repositories sharing one transaction, committing when fn returns nil
+// and rolling back otherwise. Inside a transaction, or on a store without a database such as a
+// fake, fn runs on s itself.
+func (s *Store) Transaction(fn func(tx *Store) error) error {
+	if s.db == nil {
+		return fn(s)
+	}
+
+	db, ok := s.db.db.(*sql.DB)
+	if !ok {
+		return fn(s)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(newStore(&conn{db: tx, driver: s.db.driver})); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// inTx runs the statements of fn atomically, joining the transaction db is already part of
+func inTx(db *conn, fn func(tx *conn) error) error {
+	return newStore(db).Transaction(func(tx *Store) error {
+		return fn(tx.db)
+	})
+}
+
+// nullTime stores the zero time as NULL, and other times in UTC like every stored time
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// nullInt stores the zero ID as NULL
+func nullInt(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// scanner represents a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
@@ -0,0 +1,504 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testDrivers opens a migrated database of each driver, Postgres runs on DATABASE_URL when it is set
+var testDrivers = []struct {
+	driver string
+	open   func(t *testing.T) *sql.DB
+}{
+	{DriverSQLite, func(t *testing.T) *sql.DB {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}},
+	{DriverPostgres, func(t *testing.T) *sql.DB {
+		databaseURL := os.Getenv("DATABASE_URL")
+		if databaseURL == "" {
+			t.Skip("DATABASE_URL is not set")
+		}
+		db, err := sql.Open("postgres", databaseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Ping(); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}},
+}
+
+// storeTests run on every driver, each gets a chat of its own so they also run on a shared database
+var storeTests = []struct {
+	name string
+	run  func(t *testing.T, s *Store, chat *Chat)
+}{
+	{"chats", testChats},
+	{"workspace roots", testWorkspaceRoots},
+	{"messages", testMessages},
+	{"files", testFiles},
+	{"tools", testTools},
+	{"transaction rollback", testTransactionRollback},
+	{"search", testSearch},
+}
+
+func TestStore(t *testing.T) {
+	// Times must not depend on the zone of the process
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	for _, driver := range testDrivers {
+		t.Run(driver.driver, func(t *testing.T) {
+			db := driver.open(t)
+			t.Cleanup(func() { db.Close() })
+
+			// Migrate works on the connection of the package
+			previousClient, previousStore := client, store
+			client = &conn{db: db, driver: driver.driver}
+			store = newStore(client)
+			t.Cleanup(func() { client, store = previousClient, previousStore })
+
+			if err := Migrate(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tt := range storeTests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, store, newTestChat(t, store))
+				})
+			}
+		})
+	}
+}
+
+func newTestChat(t *testing.T, s *Store) *Chat {
+	t.Helper()
+
+	chat := &Chat{
+		UUID:         uuid.New().String(),
+		Title:        "Test chat",
+		Provider:     "anthropic",
+		Model:        "test-model",
+		Temperature:  0.5,
+		MaxTokens:    1024,
+		SystemPrompt: "Be brief.",
+	}
+	if err := s.Chats.Create(chat); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Chats.Delete(chat.UUID) })
+
+	return chat
+}
+
+func newTestMessage(t *testing.T, s *Store, chat *Chat, parentID int, role string, content string) *Message {
+	t.Helper()
+
+	message := &Message{
+		UUID:                uuid.New().String(),
+		ChatID:              chat.ID,
+		ParentID:            parentID,
+		Role:                role,
+		Content:             content,
+		SystemPromptVersion: chat.SystemPromptVersion,
+	}
+	if err := s.Messages.Create(message); err != nil {
+		t.Fatal(err)
+	}
+
+	return message
+}
+
+func testChats(t *testing.T, s *Store, chat *Chat) {
+	got, err := s.Chats.GetByUUID(chat.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != chat.ID || got.Title != chat.Title || got.Temperature != 0.5 || got.SystemPromptVersion != 1 {
+		t.Errorf("GetByUUID = %+v, want %+v", got, chat)
+	}
+
+	chat.Title = "Renamed"
+	chat.Temperature = 0
+	if err := s.Chats.Update(chat); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Chats.UpdateSystemPrompt(chat, "Be thorough."); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = s.Chats.GetByID(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Renamed" || got.Temperature != 0 || got.SystemPrompt != "Be thorough." || got.SystemPromptVersion != 2 {
+		t.Errorf("GetByID after update = %+v", got)
+	}
+
+	prompts, err := s.Chats.GetSystemPrompts(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 2 || prompts[0].Version != 2 || prompts[1].Content != "Be brief." {
+		t.Errorf("GetSystemPrompts = %+v", prompts)
+	}
+
+	if err := s.Chats.Delete(chat.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Chats.GetByUUID(chat.UUID); err == nil {
+		t.Error("GetByUUID found a deleted chat")
+	}
+	if err := s.Chats.Delete(chat.UUID); err == nil {
+		t.Error("Delete of a deleted chat succeeded")
+	}
+}
+
+func testWorkspaceRoots(t *testing.T, s *Store, chat *Chat) {
+	root := &WorkspaceRoot{ChatID: chat.ID, Path: "/srv/notes", Mode: WorkspaceReadOnly}
+	if err := s.Chats.CreateWorkspaceRoot(root); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding the same path again changes it in place
+	again := &WorkspaceRoot{ChatID: chat.ID, Path: "/srv/notes", Mode: WorkspaceReadWrite, MaxFileSize: 4096}
+	if err := s.Chats.CreateWorkspaceRoot(again); err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != root.ID {
+		t.Errorf("ID = %d, want %d", again.ID, root.ID)
+	}
+
+	roots, err := s.Chats.GetWorkspaceRoots(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0].Mode != WorkspaceReadWrite || roots[0].MaxFileSize != 4096 {
+		t.Errorf("GetWorkspaceRoots = %+v", roots)
+	}
+
+	if err := s.Chats.DeleteWorkspaceRoot(chat.ID, root.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Chats.DeleteWorkspaceRoot(chat.ID, root.ID); err == nil {
+		t.Error("DeleteWorkspaceRoot of a deleted root succeeded")
+	}
+}
+
+func testMessages(t *testing.T, s *Store, chat *Chat) {
+	question := newTestMessage(t, s, chat, 0, "user", "Question")
+	answer := newTestMessage(t, s, chat, question.ID, "assistant", "Answer")
+	edit := newTestMessage(t, s, chat, 0, "user", "Edited question")
+
+	got, err := s.Messages.GetByUUID(answer.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != answer.ID || got.ParentID != question.ID || got.Status != MessageCompleted {
+		t.Errorf("GetByUUID = %+v", got)
+	}
+
+	// The newest message is the leaf of the active branch
+	assertPath(t, s, chat, edit)
+
+	if err := s.Chats.SetActiveMessage(chat.ID, answer.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertPath(t, s, chat, question, answer)
+
+	siblings, err := s.Messages.GetSiblings(*edit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(siblings) != 2 || siblings[0].ID != question.ID || siblings[1].ID != edit.ID {
+		t.Errorf("GetSiblings = %+v, want the question and its edit", siblings)
+	}
+
+	leaf, err := s.Messages.GetLatestLeaf(question.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf != answer.ID {
+		t.Errorf("GetLatestLeaf = %d, want %d", leaf, answer.ID)
+	}
+
+	answer.Content = "Better answer"
+	answer.Status = MessageStopped
+	if err := s.Messages.Update(answer); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Messages.GetByID(answer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content != "Better answer" || got.Status != MessageStopped {
+		t.Errorf("GetByID after update = %+v", got)
+	}
+}
+
+// assertPath checks the active branch of chat is want
+func assertPath(t *testing.T, s *Store, chat *Chat, want ...*Message) {
+	t.Helper()
+
+	path, err := s.Messages.GetActivePath(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != len(want) {
+		t.Fatalf("GetActivePath = %d messages, want %d", len(path), len(want))
+	}
+	for i := range path {
+		if path[i].ID != want[i].ID {
+			t.Errorf("GetActivePath[%d] = %s, want %s", i, path[i].Content, want[i].Content)
+		}
+	}
+}
+
+func testFiles(t *testing.T, s *Store, chat *Chat) {
+	file := &File{
+		UUID:        uuid.New().String(),
+		ChatID:      chat.ID,
+		Name:        "notes.txt",
+		Path:        "/tmp/notes",
+		ContentType: "text/plain",
+		Size:        42,
+		Text:        "notes",
+	}
+	if err := s.Files.Create(file); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Files.GetByUUID(file.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != file.ID || got.Name != file.Name || got.MessageID != 0 || got.Text != "" {
+		t.Errorf("GetByUUID = %+v", got)
+	}
+
+	message := newTestMessage(t, s, chat, 0, "user", "See the notes")
+	if err := s.Files.AttachToMessage(file.ID, message.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Files.AttachToMessage(file.ID, message.ID); err == nil {
+		t.Error("AttachToMessage attached a file twice")
+	}
+
+	files, err := s.Files.GetByChatID(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].MessageID != message.ID {
+		t.Errorf("GetByChatID = %+v", files)
+	}
+
+	size, err := s.Files.GetTotalSize(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 42 {
+		t.Errorf("GetTotalSize = %d, want 42", size)
+	}
+
+	if err := s.Files.Delete(file.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Files.GetByUUID(file.UUID); err == nil {
+		t.Error("GetByUUID found a deleted file")
+	}
+}
+
+func testTools(t *testing.T, s *Store, chat *Chat) {
+	tool := &Tool{
+		UUID:        uuid.New().String(),
+		Name:        "test_tool_" + uuid.New().String()[:8],
+		Description: "Tests",
+		Schema:      `{"type":"object"}`,
+	}
+	if err := s.Tools.Create(tool); err != nil {
+		t.Fatal(err)
+	}
+
+	tool.Description = "Tests more"
+	if err := s.Tools.Update(tool); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Tools.GetByName(tool.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != tool.ID || got.Description != "Tests more" {
+		t.Errorf("GetByName = %+v", got)
+	}
+	if missing, err := s.Tools.GetByName("missing_" + tool.Name); err != nil || missing != nil {
+		t.Errorf("GetByName of a missing tool = %+v, %v", missing, err)
+	}
+
+	// Enabling twice keeps one row
+	for range 2 {
+		if err := s.Tools.AddToChat(chat.ID, tool.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enabled, err := s.Tools.GetByChatID(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enabled) != 1 || enabled[0].ID != tool.ID {
+		t.Errorf("GetByChatID = %+v", enabled)
+	}
+
+	message := newTestMessage(t, s, chat, 0, "assistant", "Calling")
+	call := &ToolCall{
+		UUID:              uuid.New().String(),
+		MessageID:         message.ID,
+		ToolID:            tool.ID,
+		Input:             `{}`,
+		Approval:          ApprovalRequired,
+		ApprovalExpiresAt: time.Now().Add(time.Minute),
+	}
+	if err := s.Tools.CreateCall(call); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Tools.SetCallApproval(call.UUID, ApprovalApproved); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Tools.SetCallApproval(call.UUID, ApprovalDenied); err == nil {
+		t.Error("SetCallApproval decided a tool call twice")
+	}
+	if err := s.Tools.UpdateCallResult(call.UUID, `{"ok":true}`, ToolCallCompleted); err != nil {
+		t.Fatal(err)
+	}
+
+	gotCall, err := s.Tools.GetCallByUUID(call.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotCall.ToolName != tool.Name || gotCall.Approval != ApprovalApproved || gotCall.Status != ToolCallCompleted || gotCall.FinishedAt.IsZero() {
+		t.Errorf("GetCallByUUID = %+v", gotCall)
+	}
+
+	calls, err := s.Tools.GetCallsByChatID(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0].UUID != call.UUID {
+		t.Errorf("GetCallsByChatID = %+v", calls)
+	}
+
+	if err := s.Tools.RemoveFromChat(chat.ID, tool.ID); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := s.Tools.GetByChatID(chat.ID); err != nil || len(enabled) != 0 {
+		t.Errorf("GetByChatID after removal = %+v, %v", enabled, err)
+	}
+}
+
+func testTransactionRollback(t *testing.T, s *Store, chat *Chat) {
+	question := newTestMessage(t, s, chat, 0, "user", "Question")
+
+	failed := errors.New("failed")
+	reply := &Message{UUID: uuid.New().String(), ChatID: chat.ID, ParentID: question.ID, Role: "assistant"}
+	err := s.Transaction(func(tx *Store) error {
+		if err := tx.Chats.UpdateSystemPrompt(chat, "Changed"); err != nil {
+			return err
+		}
+		if err := tx.Messages.Create(reply); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Transaction = %v, want %v", err, failed)
+	}
+
+	if _, err := s.Messages.GetByUUID(reply.UUID); err == nil {
+		t.Error("the message created in the transaction was kept")
+	}
+	got, err := s.Chats.GetByID(chat.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SystemPromptVersion != 1 || got.SystemPrompt != "Be brief." || got.ActiveMessageID != question.ID {
+		t.Errorf("chat after rollback = %+v", got)
+	}
+}
+
+func testSearch(t *testing.T, s *Store, chat *Chat) {
+	// A term of its own keeps hits of other tests and runs out
+	term := "zq" + uuid.New().String()[:8]
+	question := newTestMessage(t, s, chat, 0, "user", "What about "+term+"?")
+	answer := newTestMessage(t, s, chat, question.ID, "assistant", "The "+term+" is here.")
+
+	// The question is older and, on SQLite, stored the way CURRENT_TIMESTAMP writes times
+	var asked any = time.Now().Add(-2 * time.Hour)
+	if s.db.driver == DriverSQLite {
+		asked = time.Now().UTC().Add(-2 * time.Hour).Format(time.DateTime)
+	}
+	if _, err := s.db.Exec(`UPDATE messages SET created_at = ? WHERE id = ?`, asked, question.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  []string
+	}{
+		{"every message", SearchQuery{Text: term}, []string{answer.UUID, question.UUID}},
+		{"role", SearchQuery{Text: term, Role: "user"}, []string{question.UUID}},
+		{"every term", SearchQuery{Text: term + " here"}, []string{answer.UUID}},
+		{"from", SearchQuery{Text: term, From: time.Now().Add(-time.Hour)}, []string{answer.UUID}},
+		{"to", SearchQuery{Text: term, To: time.Now().Add(-time.Hour)}, []string{question.UUID}},
+		{"range", SearchQuery{Text: term, From: time.Now().Add(-3 * time.Hour), To: time.Now()}, []string{answer.UUID, question.UUID}},
+	}
+
+	// The index ranks by bm25, only scans return the newest first
+	missing, err := missingIndexes(s.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ordered := len(missing) > 0
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Limit = 10
+			hits, err := s.Search.Search(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, hit := range hits {
+				if hit.Kind != SearchHitMessage || hit.ChatUUID != chat.UUID {
+					t.Errorf("unexpected hit %+v", hit)
+				}
+				got = append(got, hit.MessageUUID)
+			}
+			if !ordered {
+				slices.Sort(got)
+				slices.Sort(tt.want)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("hits = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("hits = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ToolStore represents the persistence of tools, the tools enabled per chat and their calls
+type ToolStore interface {
+	Create(tool *Tool) error
+	GetAll() ([]Tool, error)
+	GetByName(name string) (*Tool, error)
+	Update(tool *Tool) error
+	AddToChat(chatID int, toolID int) error
+	RemoveFromChat(chatID int, toolID int) error
+	GetByChatID(chatID int) ([]Tool, error)
+	CreateCall(toolCall *ToolCall) error
+	UpdateCallResult(uuid string, output string, status string) error
+	GetCallsByChatID(chatID int) ([]ToolCall, error)
+	GetCallByUUID(uuid string) (*ToolCall, error)
+	SetCallApproval(uuid string, approval string) error
+	FailPendingApprovals(reason string) error
+}
+
+type toolStore struct {
+	db *conn
+}
+
+// Create inserts the tool and sets tool.ID
+func (s *toolStore) Create(tool *Tool) error {
+	query := `
+		INSERT INTO tools (uuid, name, description, schema, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id
+	`
+
+	now := time.Now().UTC()
+	var id int
+	err := s.db.QueryRow(
+		query,
+		tool.UUID,
+		tool.Name,
+		tool.Description,
+		tool.Schema,
+		now,
+	).Scan(&id)
+
+	if err != nil {
+		return fmt.Errorf("failed to create tool: %v", err)
+	}
+
+	tool.ID = id
+	tool.CreatedAt = now
+	return nil
+}
+
+func (s *toolStore) GetAll() ([]Tool, error) {
+	query := `
+		SELECT id, uuid, name, description, schema, created_at
+		FROM tools
+		ORDER BY name ASC
+	`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all tools: %v", err)
+	}
+	defer rows.Close()
+
+	return scanTools(rows)
+}
+
+// GetByName returns nil without error when no tool has the name
+func (s *toolStore) GetByName(name string) (*Tool, error) {
+	query := `
+		SELECT id, uuid, name, description, schema, created_at
+		FROM tools
+		WHERE name = ?
+	`
+
+	var tool Tool
+	err := s.db.QueryRow(query, name).Scan(
+		&tool.ID,
+		&tool.UUID,
+		&tool.Name,
+		&tool.Description,
+		&tool.Schema,
+		&tool.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tool by name: %v", err)
+	}
+
+	return &tool, nil
+}
+
+func (s *toolStore) Update(tool *Tool) error {
+	query := `
+		UPDATE tools
+		SET description = ?, schema = ?
+		WHERE uuid = ?
+	`
+
+	_, err := s.db.Exec(
+		query,
+		tool.Description,
+		tool.Schema,
+		tool.UUID,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to update tool: %v", err)
+	}
+
+	return nil
+}
+
+func (s *toolStore) AddToChat(chatID int, toolID int) error {
+	query := `
+		INSERT INTO chat_tools (chat_id, tool_id, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (chat_id, tool_id) DO NOTHING
+	`
+
+	_, err := s.db.Exec(
+		query,
+		chatID,
+		toolID,
+		time.Now().UTC(),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to add tool to chat: %v", err)
+	}
+
+	return nil
+}
+
+func (s *toolStore) RemoveFromChat(chatID int, toolID int) error {
+	query := `
+		DELETE FROM chat_tools
+		WHERE chat_id = ? AND tool_id = ?
+	`
+
+	_, err := s.db.Exec(query, chatID, toolID)
+	if err != nil {
+		return fmt.Errorf("failed to remove tool from chat: %v", err)
+	}
+
+	return nil
+}
+
+// GetByChatID returns the tools enabled on a chat
+func (s *toolStore) GetByChatID(chatID int) ([]Tool, error) {
+	query := `
+		SELECT t.id, t.uuid, t.name, t.description, t.schema, t.created_at
+		FROM tools t
+		JOIN chat_tools ct ON ct.tool_id = t.id
+		WHERE ct.chat_id = ?
+		ORDER BY t.name ASC
+	`
+
+	rows, err := s.db.Query(query, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat tools: %v", err)
+	}
+	defer rows.Close()
+
+	return scanTools(rows)
+}
+
+func scanTools(rows *sql.Rows) ([]Tool, error) {
+	var tools []Tool
+	for rows.Next() {
+		var tool Tool
+		err := rows.Scan(
+			&tool.ID,
+			&tool.UUID,
+			&tool.Name,
+			&tool.Description,
+			&tool.Schema,
+			&tool.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tool row: %v", err)
+		}
+		tools = append(tools, tool)
+	}
+
+	return tools, nil
+}
+
+// CreateCall inserts a pending tool call and sets toolCall.ID
+func (s *toolStore) CreateCall(toolCall *ToolCall) error {
+	query := `
+		INSERT INTO tool_calls (uuid, message_id, tool_id, input, status, approval, preview, approval_expires_at, started_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+
+	now := time.Now().UTC()
+	if toolCall.StartedAt.IsZero() {
+		toolCall.StartedAt = now
+	}
+
+	var id int
+	err := s.db.QueryRow(
+		query,
+		toolCall.UUID,
+		toolCall.MessageID,
+		toolCall.ToolID,
+		toolCall.Input,
+		ToolCallPending,
+		toolCall.Approval,
+		toolCall.Preview,
+		nullTime(toolCall.ApprovalExpiresAt),
+		toolCall.StartedAt.UTC(),
+		now,
+	).Scan(&id)
+
+	if err != nil {
+		return fmt.Errorf("failed to create tool call: %v", err)
+	}
+
+	toolCall.ID = id
+	toolCall.Status = ToolCallPending
+	toolCall.CreatedAt = now
+	return nil
+}
+
+func (s *toolStore) UpdateCallResult(uuid string, output string, status string) error {
+	query := `
+		UPDATE tool_calls
+		SET output = ?, status = ?, finished_at = ?
+		WHERE uuid = ?
+	`
+
+	_, err := s.db.Exec(
+		query,
+		output,
+		status,
+		time.Now().UTC(),
+		uuid,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to update tool call result: %v", err)
+	}
+
+	return nil
+}
+
+func (s *toolStore) GetCallsByChatID(chatID int) ([]ToolCall, error) {
+	query := `
+		SELECT ` + toolCallColumns + `
+		FROM tool_calls tc
+		JOIN messages m ON m.id = tc.message_id
+		JOIN tools t ON t.id = tc.tool_id
+		WHERE m.chat_id = ?
+		ORDER BY tc.id ASC
+	`
+
+	rows, err := s.db.Query(query, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tool calls: %v", err)
+	}
+	defer rows.Close()
+
+	var toolCalls []ToolCall
+	for rows.Next() {
+		toolCall, err := scanToolCall(rows)
+		if err != nil {
+			return nil, err
+		}
+		toolCalls = append(toolCalls, *toolCall)
+	}
+
+	return toolCalls, nil
+}
+
+func (s *toolStore) GetCallByUUID(uuid string) (*ToolCall, error) {
+	query := `
+		SELECT ` + toolCallColumns + `
+		FROM tool_calls tc
+		JOIN tools t ON t.id = tc.tool_id
+		WHERE tc.uuid = ?
+	`
+
+	return scanToolCall(s.db.QueryRow(query, uuid))
+}
+
+// SetCallApproval records the decision on a tool call that is still waiting for one
+func (s *toolStore) SetCallApproval(uuid string, approval string) error {
+	query := `
+		UPDATE tool_calls
+		SET approval = ?
+		WHERE uuid = ? AND approval = ? AND status = ?
+	`
+
+	result, err := s.db.Exec(query, approval, uuid, ApprovalRequired, ToolCallPending)
+	if err != nil {
+		return fmt.Errorf("failed to set tool call approval: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no tool call awaiting approval with UUID: %s", uuid)
+	}
+
+	return nil
+}
+
+// FailPendingApprovals fails every tool call still waiting for approval
+func (s *toolStore) FailPendingApprovals(reason string) error {
+	query := `
+		UPDATE tool_calls
+		SET approval = ?, status = ?, output = ?, finished_at = ?
+		WHERE approval = ? AND status = ?
+	`
+
+	_, err := s.db.Exec(
+		query,
+		ApprovalExpired,
+		ToolCallFailed,
+		reason,
+		time.Now().UTC(),
+		ApprovalRequired,
+		ToolCallPending,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to fail pending approvals: %v", err)
+	}
+
+	return nil
+}
+
+const toolCallColumns = `tc.id, tc.uuid, tc.message_id, tc.tool_id, t.name, tc.input, tc.output, tc.status,
+			tc.approval, tc.preview, tc.approval_expires_at, tc.started_at, tc.finished_at, tc.created_at`
+
+// scanToolCall scans a row selected with toolCallColumns
+func scanToolCall(row scanner) (*ToolCall, error) {
+	var toolCall ToolCall
+	var output sql.NullString
+	var expiresAt, startedAt, finishedAt sql.NullTime
+	err := row.Scan(
+		&toolCall.ID,
+		&toolCall.UUID,
+		&toolCall.MessageID,
+		&toolCall.ToolID,
+		&toolCall.ToolName,
+		&toolCall.Input,
+		&output,
+		&toolCall.Status,
+		&toolCall.Approval,
+		&toolCall.Preview,
+		&expiresAt,
+		&startedAt,
+		&finishedAt,
+		&toolCall.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan tool call row: %v", err)
+	}
+
+	toolCall.Output = output.String
+	toolCall.ApprovalExpiresAt = expiresAt.Time
+	toolCall.StartedAt = startedAt.Time
+	toolCall.FinishedAt = finishedAt.Time
+	return &toolCall, nil
+}
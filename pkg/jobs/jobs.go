@@ -0,0 +1,308 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+	"wisdomizer/pkg/stream"
+
+	"github.com/google/uuid"
+)
+
+// Retention is how long a finished job stays available for clients replaying its events
+var Retention = 5 * time.Minute
+
+// Job represents a generation running on the server, independent of the request that started it.
+// Its events are buffered so clients can attach at any time and replay what they missed, consecutive
+// deltas share an entry so the buffer grows with the text rather than the number of deltas.
+type Job struct {
+	ID          string    `json:"id"`
+	ChatUUID    string    `json:"chat_uuid"`
+	MessageUUID string    `json:"message_uuid"` // assistant message being generated
+	StartedAt   time.Time `json:"started_at"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	entries []entry
+	lastID  int
+	changed chan struct{} // closed and replaced whenever an event is published or the job finishes
+	started bool
+	done    bool
+}
+
+// entry represents buffered events, a single event or a run of consecutive deltas
+type entry struct {
+	event stream.Event // ID of the last event of the entry, no data for a run of deltas
+	text  []byte       // text of the deltas
+	ends  []int        // end of the text of each delta in text
+}
+
+// firstID returns the ID of the first event of the entry
+func (e *entry) firstID() int {
+	if e.event.Type != stream.EventDelta {
+		return e.event.ID
+	}
+
+	return e.event.ID - len(e.ends) + 1
+}
+
+// after returns the events of the entry after the event ID after, a run of deltas as a single delta
+func (e *entry) after(after int) (stream.Event, error) {
+	if e.event.Type != stream.EventDelta {
+		return e.event, nil
+	}
+
+	start := 0
+	if seen := after - e.firstID(); seen >= 0 {
+		start = e.ends[seen]
+	}
+
+	data, err := json.Marshal(stream.Delta{Text: string(e.text[start:])})
+	if err != nil {
+		return stream.Event{}, fmt.Errorf("failed to marshal %s event: %v", stream.EventDelta, err)
+	}
+
+	event := e.event
+	event.Data = data
+	return event, nil
+}
+
+// BusyError is returned by New while another job of the chat has not finished
+type BusyError struct {
+	Job *Job // the job holding the chat
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("chat %s is busy with job %s", e.Job.ChatUUID, e.Job.ID)
+}
+
+var (
+	mu     sync.RWMutex
+	byID   = map[string]*Job{}
+	byChat = map[string]*Job{} // latest started job of each chat
+	active = map[string]*Job{} // job holding each chat, from New until it finishes or is discarded
+)
+
+// New creates a job for the assistant message messageUUID of a chat and reserves the chat for it,
+// so turns of a chat are generated one at a time. Start runs the job, Discard releases an unstarted one.
+func New(chatUUID string, messageUUID string) (*Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if running, ok := active[chatUUID]; ok {
+		return nil, &BusyError{Job: running}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:          uuid.New().String(),
+		ChatUUID:    chatUUID,
+		MessageUUID: messageUUID,
+		StartedAt:   time.Now(),
+		ctx:         ctx,
+		cancel:      cancel,
+		changed:     make(chan struct{}),
+	}
+	active[chatUUID] = job
+
+	return job, nil
+}
+
+// Discard releases the chat of a job that was not started, it does nothing once Start was called
+func (j *Job) Discard() {
+	j.mu.Lock()
+	started := j.started
+	j.mu.Unlock()
+
+	if started {
+		return
+	}
+
+	j.cancel()
+	release(j)
+}
+
+// Context is cancelled when the job is cancelled or finishes
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Start registers the job and calls run in the background, the job finishes when run returns
+func (j *Job) Start(run func(ctx context.Context)) {
+	j.mu.Lock()
+	j.started = true
+	j.mu.Unlock()
+
+	mu.Lock()
+	byID[j.ID] = j
+	byChat[j.ChatUUID] = j
+	mu.Unlock()
+
+	go func() {
+		defer j.finish()
+		run(j.ctx)
+	}()
+}
+
+// Cancel asks the job to stop, run sees its context cancelled
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Done reports whether the job has finished
+func (j *Job) Done() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.done
+}
+
+// Publish appends an event to the job, event IDs count up from 1
+func (j *Job) Publish(eventType string, data any) error {
+	delta, isDelta := data.(stream.Delta)
+	isDelta = isDelta && eventType == stream.EventDelta
+
+	var payload json.RawMessage
+	if !isDelta {
+		var err error
+		payload, err = json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s event: %v", eventType, err)
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.lastID++
+	if isDelta {
+		if len(j.entries) == 0 || j.entries[len(j.entries)-1].event.Type != stream.EventDelta {
+			j.entries = append(j.entries, entry{event: stream.Event{Type: stream.EventDelta}})
+		}
+
+		run := &j.entries[len(j.entries)-1]
+		run.event.ID = j.lastID
+		run.text = append(run.text, delta.Text...)
+		run.ends = append(run.ends, len(run.text))
+	} else {
+		j.entries = append(j.entries, entry{event: stream.Event{
+			ID:   j.lastID,
+			Type: eventType,
+			Data: payload,
+		}})
+	}
+	j.notify()
+
+	return nil
+}
+
+// Stream calls send with every event after the event ID after, then with new events as they are
+// published, until the job finishes or ctx is cancelled. Consecutive deltas are sent as one delta with
+// the ID of the last of them.
+func (j *Job) Stream(ctx context.Context, after int, send func(event stream.Event) error) error {
+	for {
+		j.mu.Lock()
+		var pending []stream.Event
+		for i := range j.entries {
+			if j.entries[i].event.ID <= after {
+				continue
+			}
+
+			event, err := j.entries[i].after(after)
+			if err != nil {
+				j.mu.Unlock()
+				return err
+			}
+			pending = append(pending, event)
+		}
+		done := j.done
+		changed := j.changed
+		j.mu.Unlock()
+
+		for _, event := range pending {
+			if err := send(event); err != nil {
+				return err
+			}
+			after = event.ID
+		}
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (j *Job) finish() {
+	retention := Retention
+	j.cancel()
+
+	j.mu.Lock()
+	j.done = true
+	j.notify()
+	j.mu.Unlock()
+
+	release(j)
+
+	time.AfterFunc(retention, func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		delete(byID, j.ID)
+		if byChat[j.ChatUUID] == j {
+			delete(byChat, j.ChatUUID)
+		}
+	})
+}
+
+// notify wakes the streams waiting for the job, j.mu must be held
+func (j *Job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// release frees the chat held by j
+func release(j *Job) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if active[j.ChatUUID] == j {
+		delete(active, j.ChatUUID)
+	}
+}
+
+// Running returns the job holding a chat
+func Running(chatUUID string) (*Job, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	job, ok := active[chatUUID]
+	return job, ok
+}
+
+// Get returns a running or recently finished job
+func Get(id string) (*Job, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	job, ok := byID[id]
+	return job, ok
+}
+
+// Latest returns the most recent job of a chat, running or recently finished
+func Latest(chatUUID string) (*Job, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	job, ok := byChat[chatUUID]
+	return job, ok
+}
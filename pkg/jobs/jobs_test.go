@@ -0,0 +1,290 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+	"wisdomizer/pkg/stream"
+
+	"github.com/google/uuid"
+)
+
+// event is a published event, with the text of a delta
+type event struct {
+	Type string
+	Text string
+}
+
+var published = []event{
+	{Type: stream.EventMessageStart},
+	{Type: stream.EventDelta, Text: "Hel"},
+	{Type: stream.EventDelta, Text: "lo"},
+	{Type: stream.EventDelta, Text: " world"},
+	{Type: stream.EventToolCall},
+	{Type: stream.EventDelta, Text: "!"},
+	{Type: stream.EventDone},
+}
+
+func publish(t *testing.T, job *Job, events []event) {
+	t.Helper()
+
+	for _, e := range events {
+		var data any = struct{}{}
+		if e.Type == stream.EventDelta {
+			data = stream.Delta{Text: e.Text}
+		}
+		if err := job.Publish(e.Type, data); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// collect streams a job after the event ID after, joining consecutive delta texts like a client
+func collect(t *testing.T, job *Job, after int) []event {
+	t.Helper()
+
+	var got []event
+	lastID := after
+	err := job.Stream(context.Background(), after, func(e stream.Event) error {
+		if e.ID <= lastID {
+			t.Errorf("event ID %d after %d", e.ID, lastID)
+		}
+		lastID = e.ID
+
+		if e.Type != stream.EventDelta {
+			got = append(got, event{Type: e.Type})
+			return nil
+		}
+
+		var delta stream.Delta
+		if err := json.Unmarshal(e.Data, &delta); err != nil {
+			return err
+		}
+		if len(got) > 0 && got[len(got)-1].Type == stream.EventDelta {
+			got[len(got)-1].Text += delta.Text
+		} else {
+			got = append(got, event{Type: stream.EventDelta, Text: delta.Text})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+// join merges consecutive deltas of events
+func join(events []event) []event {
+	var joined []event
+	for _, e := range events {
+		if e.Type == stream.EventDelta && len(joined) > 0 && joined[len(joined)-1].Type == stream.EventDelta {
+			joined[len(joined)-1].Text += e.Text
+			continue
+		}
+		joined = append(joined, e)
+	}
+
+	return joined
+}
+
+func equalEvents(a []event, b []event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestNewBusy(t *testing.T) {
+	chatUUID := uuid.New().String()
+
+	job, err := New(chatUUID, "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(chatUUID, "other")
+	var busy *BusyError
+	if !errors.As(err, &busy) || busy.Job != job {
+		t.Fatalf("New on a busy chat error = %v, want a BusyError for %s", err, job.ID)
+	}
+	if running, ok := Running(chatUUID); !ok || running != job {
+		t.Errorf("Running = %v, %v", running, ok)
+	}
+
+	// Discarding frees the chat of an unstarted job
+	job.Discard()
+	if job.Context().Err() == nil {
+		t.Error("discarded job context is not cancelled")
+	}
+	if _, ok := Running(chatUUID); ok {
+		t.Error("discarded job still holds the chat")
+	}
+
+	job, err = New(chatUUID, "message")
+	if err != nil {
+		t.Fatalf("New after Discard error = %v", err)
+	}
+
+	// Discard does nothing once the job started, the chat is released when it finishes
+	release := make(chan struct{})
+	job.Start(func(ctx context.Context) { <-release })
+	job.Discard()
+	if _, err := New(chatUUID, "other"); !errors.As(err, &busy) {
+		t.Errorf("New while the job runs error = %v, want a BusyError", err)
+	}
+	if job.Context().Err() != nil {
+		t.Error("Discard cancelled a started job")
+	}
+
+	close(release)
+	collect(t, job, 0)
+	if !job.Done() {
+		t.Error("job is not done")
+	}
+	if _, err := New(chatUUID, "other"); err != nil {
+		t.Errorf("New after the job finished error = %v", err)
+	}
+}
+
+func TestStreamReplay(t *testing.T) {
+	job, err := New(uuid.New().String(), "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Start(func(ctx context.Context) { publish(t, job, published) })
+
+	// Every event ID resumes exactly after that event
+	for after := 0; after <= len(published); after++ {
+		got := collect(t, job, after)
+		if want := join(published[after:]); !equalEvents(got, want) {
+			t.Errorf("after %d = %+v, want %+v", after, got, want)
+		}
+	}
+
+	// The deltas before the tool call share an entry
+	if len(job.entries) != 5 {
+		t.Errorf("entries = %d, want 5", len(job.entries))
+	}
+}
+
+func TestStreamLive(t *testing.T) {
+	job, err := New(uuid.New().String(), "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := make(chan struct{})
+	job.Start(func(ctx context.Context) {
+		for _, e := range published {
+			<-next
+			publish(t, job, []event{e})
+		}
+	})
+
+	// A listener attached from the start receives every event as it is published
+	done := make(chan []event)
+	go func() { done <- collect(t, job, 0) }()
+	for range published {
+		next <- struct{}{}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got, want := <-done, join(published); !equalEvents(got, want) {
+		t.Errorf("streamed = %+v, want %+v", got, want)
+	}
+}
+
+func TestStreamCancelled(t *testing.T) {
+	job, err := New(uuid.New().String(), "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Start(func(ctx context.Context) { <-ctx.Done() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := job.Stream(ctx, 0, func(stream.Event) error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stream error = %v, want the context error", err)
+	}
+	if job.Done() {
+		t.Error("the job stopped with its listener")
+	}
+
+	job.Cancel()
+	collect(t, job, 0)
+}
+
+func TestRetention(t *testing.T) {
+	retention := Retention
+	Retention = 20 * time.Millisecond
+	t.Cleanup(func() { Retention = retention })
+
+	chatUUID := uuid.New().String()
+	job, err := New(chatUUID, "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Start(func(ctx context.Context) { publish(t, job, published) })
+	collect(t, job, 0)
+
+	// A finished job stays available for replay
+	if got, ok := Get(job.ID); !ok || got != job {
+		t.Errorf("Get after finish = %v, %v", got, ok)
+	}
+	if got, ok := Latest(chatUUID); !ok || got != job {
+		t.Errorf("Latest after finish = %v, %v", got, ok)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, byID := Get(job.ID)
+		_, latest := Latest(chatUUID)
+		if !byID && !latest {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still available after retention: Get %v, Latest %v", byID, latest)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRetentionKeepsNewerJob(t *testing.T) {
+	retention := Retention
+	Retention = 20 * time.Millisecond
+	t.Cleanup(func() { Retention = retention })
+
+	chatUUID := uuid.New().String()
+	first, err := New(chatUUID, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Start(func(ctx context.Context) {})
+	collect(t, first, 0)
+
+	// The expiry of the first job must not remove the newer one as the latest of the chat
+	Retention = time.Minute
+	second, err := New(chatUUID, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Start(func(ctx context.Context) {})
+	collect(t, second, 0)
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := Get(first.ID); ok {
+		t.Error("first job still available after retention")
+	}
+	if got, ok := Latest(chatUUID); !ok || got != second {
+		t.Errorf("Latest = %v, %v, want the second job", got, ok)
+	}
+}
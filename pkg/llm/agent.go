@@ -0,0 +1,233 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stop reasons set by Run when a limit ends the loop
+const (
+	StopMaxSteps  = "max_steps"
+	StopMaxTokens = "max_total_tokens"
+	StopTimeout   = "timeout"
+	StopCancelled = "cancelled"
+)
+
+// DefaultMaxSteps bounds agent runs when AGENT_MAX_STEPS is not set
+const DefaultMaxSteps = 10
+
+// stepSeparator joins the texts of the steps of a run
+const stepSeparator = "\n\n"
+
+// Limits bounds an agent run, a zero value disables the limit
+type Limits struct {
+	MaxSteps  int           `json:"max_steps"`
+	MaxTokens int           `json:"max_tokens"` // input and output tokens across all steps
+	Timeout   time.Duration `json:"timeout"`
+}
+
+// Step represents a single model turn of an agent run with the tools it called
+type Step struct {
+	Index       int            `json:"index"`
+	Text        string         `json:"text"`
+	ToolCalls   []ContentBlock `json:"tool_calls,omitempty"`
+	ToolResults []ContentBlock `json:"tool_results,omitempty"`
+	StopReason  string         `json:"stop_reason"`
+	Usage       Usage          `json:"usage"`
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at"`
+}
+
+// Transcript represents the outcome of an agent run
+type Transcript struct {
+	Model      string `json:"model"`
+	Steps      []Step `json:"steps"`
+	Content    string `json:"content"` // text of all steps, separated by a blank line
+	StopReason string `json:"stop_reason"`
+	Usage      Usage  `json:"usage"`
+}
+
+// LimitsFromEnv reads AGENT_MAX_STEPS, AGENT_MAX_TOKENS and AGENT_TIMEOUT (a Go duration)
+func LimitsFromEnv() Limits {
+	limits := Limits{MaxSteps: DefaultMaxSteps}
+
+	if value, err := strconv.Atoi(os.Getenv("AGENT_MAX_STEPS")); err == nil {
+		limits.MaxSteps = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("AGENT_MAX_TOKENS")); err == nil {
+		limits.MaxTokens = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("AGENT_TIMEOUT")); err == nil {
+		limits.Timeout = value
+	}
+
+	return limits
+}
+
+// Run sends req to provider and executes the requested tools with req.ToolHandler until the model
+// stops calling tools or a limit is reached. The token budget is checked after every step. The transcript is returned with any error so the
+// completed steps are not lost, its content includes the text streamed by an interrupted step.
+// Cancelling ctx stops the run with StopCancelled and ctx's error. The timeout also interrupts a
+// provider call or tool in progress, the run then stops with StopTimeout and no error.
+func Run(ctx context.Context, provider Provider, req Request, limits Limits) (*Transcript, error) {
+	transcript := &Transcript{}
+
+	var deadline time.Time
+	if limits.Timeout > 0 {
+		deadline = time.Now().Add(limits.Timeout)
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	timedOut := func() bool {
+		return !deadline.IsZero() && errors.Is(ctx.Err(), context.DeadlineExceeded)
+	}
+
+	messages := make([]Message, len(req.Messages))
+	copy(messages, req.Messages)
+
+	var text strings.Builder
+	defer func() {
+		transcript.Content = text.String()
+	}()
+
+	// Streamed text of the current step, kept when the step fails halfway. The stream separates
+	// the steps like the content.
+	var partial strings.Builder
+	if callback := req.Callback; callback != nil {
+		req.Callback = func(delta Delta) {
+			if delta.Type == DeltaText && delta.Text != "" {
+				if partial.Len() == 0 && text.Len() > 0 {
+					delta.Text = stepSeparator + delta.Text
+				}
+				partial.WriteString(delta.Text)
+			}
+			callback(delta)
+		}
+	}
+
+	for index := 0; ; index++ {
+		if limits.MaxSteps > 0 && index >= limits.MaxSteps {
+			transcript.StopReason = StopMaxSteps
+			return transcript, nil
+		}
+		if timedOut() {
+			transcript.StopReason = StopTimeout
+			return transcript, nil
+		}
+		if err := ctx.Err(); err != nil {
+			transcript.StopReason = StopCancelled
+			return transcript, err
+		}
+
+		stepReq := req
+		stepReq.Messages = messages
+
+		step := Step{
+			Index:     index,
+			StartedAt: time.Now(),
+		}
+
+		partial.Reset()
+		resp, err := provider.Chat(ctx, stepReq)
+		if err != nil {
+			text.WriteString(partial.String())
+			if timedOut() {
+				transcript.StopReason = StopTimeout
+				return transcript, nil
+			}
+			if ctx.Err() != nil {
+				transcript.StopReason = StopCancelled
+				return transcript, ctx.Err()
+			}
+			return transcript, fmt.Errorf("step %d: %w", index, err)
+		}
+
+		step.Text = resp.Content
+		step.ToolCalls = resp.ToolCalls()
+		step.StopReason = resp.StopReason
+		step.Usage = resp.Usage
+
+		if text.Len() > 0 && resp.Content != "" {
+			text.WriteString(stepSeparator)
+		}
+		text.WriteString(resp.Content)
+		transcript.Model = resp.Model
+		transcript.StopReason = resp.StopReason
+		transcript.Usage.InputTokens += resp.Usage.InputTokens
+		transcript.Usage.OutputTokens += resp.Usage.OutputTokens
+
+		if req.Callback != nil {
+			usage := resp.Usage
+			req.Callback(Delta{Type: DeltaUsage, Usage: &usage})
+		}
+
+		if len(step.ToolCalls) > 0 {
+			step.ToolResults = runTools(ctx, step.ToolCalls, req)
+		}
+		step.FinishedAt = time.Now()
+		transcript.Steps = append(transcript.Steps, step)
+
+		if limits.MaxTokens > 0 && transcript.Usage.InputTokens+transcript.Usage.OutputTokens >= limits.MaxTokens {
+			transcript.StopReason = StopMaxTokens
+			return transcript, nil
+		}
+
+		if len(step.ToolCalls) == 0 {
+			return transcript, nil
+		}
+
+		// The assistant turn is replayed as-is, followed by a user turn carrying the results
+		messages = append(messages,
+			Message{Role: RoleAssistant, Content: resp.Blocks},
+			Message{Role: RoleUser, Content: step.ToolResults},
+		)
+	}
+}
+
+// runTools answers every tool_use block with a matching tool_result block,
+// the tools left when ctx is cancelled are not run
+func runTools(ctx context.Context, calls []ContentBlock, req Request) []ContentBlock {
+	results := make([]ContentBlock, 0, len(calls))
+	for _, call := range calls {
+		if req.Callback != nil {
+			block := call
+			req.Callback(Delta{Type: DeltaToolStart, Block: &block})
+		}
+
+		result := ContentBlock{
+			Type:       BlockToolResult,
+			ToolCallID: call.ToolCallID,
+			Name:       call.Name,
+		}
+
+		// Tool failures are reported back to the model instead of aborting the run
+		if ctx.Err() != nil {
+			result.IsError = true
+			result.Text = "cancelled before the tool ran"
+		} else if req.ToolHandler == nil {
+			result.IsError = true
+			result.Text = fmt.Sprintf("no tool handler for tool: %s", call.Name)
+		} else if output, err := req.ToolHandler(ctx, call); err != nil {
+			result.IsError = true
+			result.Text = err.Error()
+		} else {
+			result.Text = string(output)
+		}
+
+		if req.Callback != nil {
+			block := result
+			req.Callback(Delta{Type: DeltaToolFinish, Block: &block})
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
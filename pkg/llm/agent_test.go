@@ -0,0 +1,248 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// scriptedProvider answers the calls of a run with the steps of its script in order
+type scriptedProvider struct {
+	script   []func(ctx context.Context, req Request) (*Response, error)
+	requests []Request
+}
+
+func (p *scriptedProvider) Name() string         { return "scripted" }
+func (p *scriptedProvider) DefaultModel() string { return "scripted-model" }
+
+func (p *scriptedProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	p.requests = append(p.requests, req)
+	if len(p.requests) > len(p.script) {
+		return nil, fmt.Errorf("unexpected call %d", len(p.requests))
+	}
+
+	return p.script[len(p.requests)-1](ctx, req)
+}
+
+// reply returns a step answering with text and calling the given tools, each step using 10 tokens
+func reply(text string, tools ...string) func(ctx context.Context, req Request) (*Response, error) {
+	return func(ctx context.Context, req Request) (*Response, error) {
+		if req.Callback != nil {
+			req.Callback(Delta{Type: DeltaText, Text: text})
+		}
+
+		resp := &Response{
+			Model:      "scripted-model",
+			Content:    text,
+			Blocks:     []ContentBlock{{Type: BlockText, Text: text}},
+			StopReason: "end_turn",
+			Usage:      Usage{InputTokens: 6, OutputTokens: 4},
+		}
+		for _, tool := range tools {
+			resp.Blocks = append(resp.Blocks, ContentBlock{Type: BlockToolUse, ToolCallID: "call-" + tool, Name: tool, Input: json.RawMessage(`{}`)})
+			resp.StopReason = "tool_use"
+		}
+
+		return resp, nil
+	}
+}
+
+// hang streams text, then waits for the run to end it
+func hang(text string) func(ctx context.Context, req Request) (*Response, error) {
+	return func(ctx context.Context, req Request) (*Response, error) {
+		if req.Callback != nil {
+			req.Callback(Delta{Type: DeltaText, Text: text})
+		}
+
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+}
+
+func echoTool(ctx context.Context, call ContentBlock) (json.RawMessage, error) {
+	return json.RawMessage(`"ran ` + call.Name + `"`), nil
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     []func(ctx context.Context, req Request) (*Response, error)
+		limits     Limits
+		cancel     bool // cancel the run from the tool handler
+		stopReason string
+		steps      int
+		content    string
+		wantErr    error
+		failed     bool // the provider fails, with an error of its own
+	}{
+		{
+			name:       "runs tools until the model stops",
+			script:     []func(ctx context.Context, req Request) (*Response, error){reply("Looking.", "search"), reply("Found it.")},
+			stopReason: "end_turn",
+			steps:      2,
+			content:    "Looking.\n\nFound it.",
+		},
+		{
+			name:       "max steps",
+			script:     []func(ctx context.Context, req Request) (*Response, error){reply("One.", "search"), reply("Two.", "search"), reply("Three.")},
+			limits:     Limits{MaxSteps: 2},
+			stopReason: StopMaxSteps,
+			steps:      2,
+			content:    "One.\n\nTwo.",
+		},
+		{
+			name:       "token budget reached by a step calling tools",
+			script:     []func(ctx context.Context, req Request) (*Response, error){reply("One.", "search"), reply("Two.", "search"), reply("Three.")},
+			limits:     Limits{MaxTokens: 20},
+			stopReason: StopMaxTokens,
+			steps:      2,
+			content:    "One.\n\nTwo.",
+		},
+		{
+			name:       "token budget reached by the last step",
+			script:     []func(ctx context.Context, req Request) (*Response, error){reply("One.", "search"), reply("Two.")},
+			limits:     Limits{MaxTokens: 15},
+			stopReason: StopMaxTokens,
+			steps:      2,
+			content:    "One.\n\nTwo.",
+		},
+		{
+			name:       "token budget not reached",
+			script:     []func(ctx context.Context, req Request) (*Response, error){reply("One.", "search"), reply("Two.")},
+			limits:     Limits{MaxTokens: 21},
+			stopReason: "end_turn",
+			steps:      2,
+			content:    "One.\n\nTwo.",
+		},
+		{
+			name:       "timeout keeps the streamed text",
+			script:     []func(ctx context.Context, req Request) (*Response, error){reply("One.", "search"), hang("Partial")},
+			limits:     Limits{Timeout: 50 * time.Millisecond},
+			stopReason: StopTimeout,
+			steps:      1,
+			content:    "One.\n\nPartial",
+		},
+		{
+			// The step is recorded with its results, the read tool never runs
+			name:       "cancelled during a tool",
+			script:     []func(ctx context.Context, req Request) (*Response, error){reply("One.", "search", "read")},
+			cancel:     true,
+			stopReason: StopCancelled,
+			steps:      1,
+			content:    "One.",
+			wantErr:    context.Canceled,
+		},
+		{
+			// The script runs out on the second step
+			name:       "provider error",
+			script:     []func(ctx context.Context, req Request) (*Response, error){reply("One.", "search")},
+			stopReason: "tool_use",
+			steps:      1,
+			content:    "One.",
+			failed:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			handler := ToolHandler(echoTool)
+			if tt.cancel {
+				handler = func(ctx context.Context, call ContentBlock) (json.RawMessage, error) {
+					cancel()
+					return echoTool(ctx, call)
+				}
+			}
+
+			var streamed string
+			provider := &scriptedProvider{script: tt.script}
+			transcript, err := Run(ctx, provider, Request{
+				Messages:    []Message{TextMessage(RoleUser, "Find it")},
+				ToolHandler: handler,
+				Stream:      true,
+				Callback: func(delta Delta) {
+					if delta.Type == DeltaText {
+						streamed += delta.Text
+					}
+				},
+			}, tt.limits)
+
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.failed:
+				if err == nil {
+					t.Fatal("error = nil, want the provider error")
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if transcript.StopReason != tt.stopReason {
+				t.Errorf("stop reason = %q, want %q", transcript.StopReason, tt.stopReason)
+			}
+			if len(transcript.Steps) != tt.steps {
+				t.Errorf("steps = %d, want %d", len(transcript.Steps), tt.steps)
+			}
+			if transcript.Content != tt.content {
+				t.Errorf("content = %q, want %q", transcript.Content, tt.content)
+			}
+			if tt.stopReason != StopTimeout && tt.stopReason != StopCancelled && streamed != transcript.Content {
+				t.Errorf("streamed = %q, want the content %q", streamed, transcript.Content)
+			}
+			if usage := transcript.Usage.InputTokens + transcript.Usage.OutputTokens; usage != 10*len(transcript.Steps) {
+				t.Errorf("usage = %d tokens, want %d", usage, 10*len(transcript.Steps))
+			}
+		})
+	}
+}
+
+func TestRunToolResults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := &scriptedProvider{script: []func(ctx context.Context, req Request) (*Response, error){
+		reply("Looking.", "search", "read"),
+		reply("Done."),
+	}}
+	var ran []string
+	transcript, err := Run(ctx, provider, Request{
+		Messages: []Message{TextMessage(RoleUser, "Find it")},
+		ToolHandler: func(ctx context.Context, call ContentBlock) (json.RawMessage, error) {
+			ran = append(ran, call.Name)
+			if call.Name == "read" {
+				return nil, errors.New("no such file")
+			}
+			return echoTool(ctx, call)
+		},
+	}, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(ran, []string{"search", "read"}) {
+		t.Errorf("ran = %v", ran)
+	}
+
+	results := transcript.Steps[0].ToolResults
+	if len(results) != 2 || results[0].Text != `"ran search"` || results[0].IsError || !results[1].IsError || results[1].ToolCallID != "call-read" {
+		t.Errorf("tool results = %+v", results)
+	}
+
+	// The second step sees the assistant turn and the results
+	messages := provider.requests[1].Messages
+	if len(messages) != 3 || messages[1].Role != RoleAssistant || messages[2].Role != RoleUser || len(messages[2].Content) != 2 {
+		t.Errorf("messages of the second step = %+v", messages)
+	}
+	if len(provider.requests[0].Messages) != 1 {
+		t.Errorf("the first step saw %d messages, want 1", len(provider.requests[0].Messages))
+	}
+}
@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Roles used in Message.Role
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Content block types
+const (
+	BlockText       = "text"
+	BlockToolUse    = "tool_use"
+	BlockToolResult = "tool_result"
+	BlockImage      = "image"
+	BlockDocument   = "document"
+)
+
+// ContentBlock represents a typed piece of message content
+type ContentBlock struct {
+	Type       string          `json:"type"`
+	Text       string          `json:"text,omitempty"`         // text, tool_result output
+	ToolCallID string          `json:"tool_call_id,omitempty"` // tool_use, tool_result
+	Name       string          `json:"name,omitempty"`         // tool_use, document title
+	Input      json.RawMessage `json:"input,omitempty"`        // tool_use
+	IsError    bool            `json:"is_error,omitempty"`     // tool_result
+	MediaType  string          `json:"media_type,omitempty"`   // image, document
+	Data       []byte          `json:"data,omitempty"`         // image, document
+}
+
+// Message represents a single turn in the conversation
+type Message struct {
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
+}
+
+// TextMessage creates a message with a single text block
+func TextMessage(role string, text string) Message {
+	return Message{
+		Role:    role,
+		Content: []ContentBlock{{Type: BlockText, Text: text}},
+	}
+}
+
+// Text returns the concatenated text blocks of the message
+func (m Message) Text() string {
+	var text strings.Builder
+	for _, block := range m.Content {
+		if block.Type == BlockText {
+			text.WriteString(block.Text)
+		}
+	}
+
+	return text.String()
+}
+
+// Tool represents a function that can be called during the model's generation process
+type Tool struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	InputSchema JSONSchema `json:"input_schema"`
+}
+
+// JSONSchema represents the schema of a tool's input
+type JSONSchema struct {
+	Type       string              `json:"type"`
+	Properties map[string]Property `json:"properties,omitempty"`
+	Required   []string            `json:"required,omitempty"`
+}
+
+// Property represents a property in a JSON schema
+type Property struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+}
+
+// ToolHandler executes a tool_use block and returns its JSON encoded output, it should stop waiting
+// once ctx is done. An error is reported back to the model as a failed tool result.
+type ToolHandler func(ctx context.Context, call ContentBlock) (json.RawMessage, error)
+
+// Request represents a provider-agnostic chat request
+type Request struct {
+	Model       string            `json:"model,omitempty"`
+	System      string            `json:"system,omitempty"`
+	Messages    []Message         `json:"messages"`
+	MaxTokens   int               `json:"max_tokens,omitempty"`
+	Temperature *float64          `json:"temperature,omitempty"` // nil uses DefaultTemperature, 0 is a valid value
+	Tools       []Tool            `json:"tools,omitempty"`
+	ToolHandler ToolHandler       `json:"-"`
+	Stream      bool              `json:"stream,omitempty"`
+	Callback    func(delta Delta) `json:"-"` // only for stream
+}
+
+// TemperatureOrDefault returns the requested temperature, or DefaultTemperature when none is set
+func (r Request) TemperatureOrDefault() float64 {
+	if r.Temperature == nil {
+		return DefaultTemperature
+	}
+
+	return *r.Temperature
+}
+
+// Delta types
+const (
+	DeltaText       = "text"
+	DeltaToolStart  = "tool_start"
+	DeltaToolFinish = "tool_finish"
+	DeltaUsage      = "usage"
+)
+
+// Delta represents an incremental piece of a streamed response.
+// Tool events carry the tool_use block on start and the tool_result block on finish,
+// usage events the usage of a finished agent step.
+type Delta struct {
+	Type  string        `json:"type"`
+	Text  string        `json:"text,omitempty"`
+	Block *ContentBlock `json:"block,omitempty"`
+	Usage *Usage        `json:"usage,omitempty"`
+}
+
+// Usage represents the token accounting of a response
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Response represents a provider-agnostic chat response of a single model turn
+type Response struct {
+	ID         string         `json:"id"`
+	Model      string         `json:"model"`
+	Content    string         `json:"content"` // text of the turn
+	Blocks     []ContentBlock `json:"blocks"`  // text and tool_use blocks in order
+	StopReason string         `json:"stop_reason"`
+	Usage      Usage          `json:"usage"`
+}
+
+// ToolCalls returns the tool_use blocks of the response
+func (r *Response) ToolCalls() []ContentBlock {
+	var calls []ContentBlock
+	for _, block := range r.Blocks {
+		if block.Type == BlockToolUse {
+			calls = append(calls, block)
+		}
+	}
+
+	return calls
+}
@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Defaults used when a chat has no generation settings configured
+const (
+	DefaultProvider    = "anthropic"
+	DefaultTemperature = 0.7
+	DefaultMaxTokens   = 4096
+	DefaultSystem      = "You are a helpful AI assistant."
+)
+
+// Provider is implemented by every LLM vendor.
+// Chat aborts the upstream request when ctx is cancelled.
+type Provider interface {
+	Name() string
+	DefaultModel() string
+	Chat(ctx context.Context, req Request) (*Response, error)
+}
+
+// ImageLimits bounds the images a provider accepts, larger images are scaled down before they are sent
+type ImageLimits struct {
+	MaxEdge  int // longest edge in pixels
+	MaxBytes int // encoded size
+}
+
+// ImageLimiter is implemented by providers that accept image content blocks
+type ImageLimiter interface {
+	ImageLimits() ImageLimits
+}
+
+// DocumentLimits bounds the PDF documents a provider reads natively, larger ones are sent as text
+type DocumentLimits struct {
+	MaxPages int
+	MaxBytes int64
+}
+
+// DocumentReader is implemented by providers that can read PDF documents natively, ok is false when
+// that is turned off
+type DocumentReader interface {
+	DocumentLimits() (limits DocumentLimits, ok bool)
+}
+
+// TemperatureLimiter is implemented by providers accepting a narrower temperature range than 0 to 2
+type TemperatureLimiter interface {
+	MaxTemperature() float64
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+)
+
+// Register makes a provider available by its name
+func Register(provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	providers[provider.Name()] = provider
+}
+
+// Get returns the provider registered under name
+func Get(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+
+	return provider, nil
+}
+
+// Names returns the names of all registered providers
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,172 @@
+// Package stream defines the server-sent events of a generation, written by POST /chat and
+// GET /chat/:uuid/stream.
+//
+// Every event is a named SSE frame with an ID and a single line of JSON data:
+//
+//	id: 2
+//	event: delta
+//	data: {"text":"Hello"}
+//
+// A stream carries, in order:
+//
+//	message_start      MessageStart, once, before anything else
+//	delta              Delta, a piece of assistant text
+//	tool_call          ToolCall, a tool starting (phase "start") or finishing (phase "finish")
+//	approval_required  ApprovalRequired, a mutating tool waiting for POST /tool-calls/:uuid/approve or /deny
+//	usage              Usage, the tokens of one model turn
+//	error              Error, the generation failed, the text streamed so far is kept
+//	done               Done, always the last event
+//
+// Generations run on the server whether or not a client is listening. IDs count up from 1 within a
+// generation, a client that lost the connection passes the job_id of message_start and the last ID
+// it saw to GET /chat/:uuid/stream?job_id=<job>&after=<id> (or as Last-Event-ID) to replay the rest.
+// Without job_id the latest generation of the chat is replayed from the start.
+//
+// Requests rejected before generation starts get a plain JSON error response instead.
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"wisdomizer/models"
+	"wisdomizer/pkg/llm"
+)
+
+// Event names
+const (
+	EventMessageStart     = "message_start"
+	EventDelta            = "delta"
+	EventToolCall         = "tool_call"
+	EventApprovalRequired = "approval_required"
+	EventUsage            = "usage"
+	EventError            = "error"
+	EventDone             = "done"
+)
+
+// Tool call phases
+const (
+	PhaseStart  = "start"
+	PhaseFinish = "finish"
+)
+
+// MessageStart represents the message_start event
+type MessageStart struct {
+	JobID                string `json:"job_id"`
+	ChatUUID             string `json:"chat_uuid"`
+	UserMessageUUID      string `json:"user_message_uuid"`
+	AssistantMessageUUID string `json:"assistant_message_uuid"`
+	Provider             string `json:"provider"`
+	Model                string `json:"model"`
+}
+
+// Delta represents the delta event
+type Delta struct {
+	Text string `json:"text"`
+}
+
+// ToolCall represents the tool_call event
+type ToolCall struct {
+	Phase   string          `json:"phase"`
+	ID      string          `json:"id"` // tool_use id assigned by the provider
+	Name    string          `json:"name"`
+	Input   json.RawMessage `json:"input,omitempty"`  // set on start
+	Output  string          `json:"output,omitempty"` // set on finish
+	IsError bool            `json:"is_error,omitempty"`
+}
+
+// ApprovalRequired represents the approval_required event
+type ApprovalRequired struct {
+	ToolCall models.ToolCall `json:"tool_call"`
+}
+
+// Usage represents the usage event
+type Usage struct {
+	llm.Usage
+}
+
+// Error represents the error event
+type Error struct {
+	Message string `json:"message"`
+}
+
+// Done represents the done event
+type Done struct {
+	AssistantMessageUUID string    `json:"assistant_message_uuid"`
+	Status               string    `json:"status"` // completed, stopped or failed, as saved on the message
+	StopReason           string    `json:"stop_reason,omitempty"`
+	Usage                llm.Usage `json:"usage"` // total of all model turns
+	Topic                string    `json:"topic"`
+}
+
+// Event represents one buffered event of a chat stream
+type Event struct {
+	ID   int             `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// FromDelta translates a streamed llm.Delta into its event, ok is false for deltas without one
+func FromDelta(delta llm.Delta) (eventType string, data any, ok bool) {
+	switch delta.Type {
+	case llm.DeltaText:
+		return EventDelta, Delta{Text: delta.Text}, true
+	case llm.DeltaToolStart:
+		return EventToolCall, ToolCall{
+			Phase: PhaseStart,
+			ID:    delta.Block.ToolCallID,
+			Name:  delta.Block.Name,
+			Input: delta.Block.Input,
+		}, true
+	case llm.DeltaToolFinish:
+		return EventToolCall, ToolCall{
+			Phase:   PhaseFinish,
+			ID:      delta.Block.ToolCallID,
+			Name:    delta.Block.Name,
+			Output:  delta.Block.Text,
+			IsError: delta.Block.IsError,
+		}, true
+	case llm.DeltaUsage:
+		return EventUsage, Usage{Usage: *delta.Usage}, true
+	}
+
+	return "", nil, false
+}
+
+// Writer writes events to a response, it is safe for concurrent use
+type Writer struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	started bool
+}
+
+// NewWriter creates a Writer, the SSE headers are sent with the first event
+func NewWriter(w http.ResponseWriter) *Writer {
+	return &Writer{w: w}
+}
+
+// Send writes one event and flushes it to the client
+func (s *Writer) Send(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		header := s.w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data); err != nil {
+		return fmt.Errorf("failed to write %s event: %v", event.Type, err)
+	}
+
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
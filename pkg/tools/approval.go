@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"wisdomizer/models"
+)
+
+// DefaultApprovalTimeout is used when APPROVAL_TIMEOUT is not set
+const DefaultApprovalTimeout = 10 * time.Minute
+
+type approvalDecision struct {
+	approved bool
+	reason   string
+}
+
+var (
+	approvalsMu sync.Mutex
+	approvals   = map[string]chan approvalDecision{}
+)
+
+// Decide delivers the user's decision to the agent loop waiting on a tool call.
+// It reports false when no loop is waiting, e.g. after a server restart.
+func Decide(toolCallUUID string, approved bool, reason string) bool {
+	approvalsMu.Lock()
+	decision, ok := approvals[toolCallUUID]
+	approvalsMu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	select {
+	case decision <- approvalDecision{approved: approved, reason: reason}:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExpireApprovals fails the approvals left pending by a previous process, nothing waits on them anymore
+func ExpireApprovals() error {
+	return models.Default().Tools.FailPendingApprovals("approval expired: the server restarted before a decision was made")
+}
+
+func approvalTimeout() time.Duration {
+	if value, err := time.ParseDuration(os.Getenv("APPROVAL_TIMEOUT")); err == nil && value > 0 {
+		return value
+	}
+
+	return DefaultApprovalTimeout
+}
+
+func awaitApproval(toolCallUUID string) chan approvalDecision {
+	decision := make(chan approvalDecision, 1)
+
+	approvalsMu.Lock()
+	approvals[toolCallUUID] = decision
+	approvalsMu.Unlock()
+
+	return decision
+}
+
+func forgetApproval(toolCallUUID string) {
+	approvalsMu.Lock()
+	delete(approvals, toolCallUUID)
+	approvalsMu.Unlock()
+}
+
+// waitForApproval blocks until the tool call is decided, expires or ctx is cancelled,
+// returning the reason on refusal
+func waitForApproval(ctx context.Context, toolCall *models.ToolCall, decision chan approvalDecision) (string, bool) {
+	timer := time.NewTimer(time.Until(toolCall.ApprovalExpiresAt))
+	defer timer.Stop()
+
+	select {
+	case d := <-decision:
+		return deniedReason(d), d.approved
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "generation timed out before approval", false
+		}
+		return "generation cancelled before approval", false
+	case <-timer.C:
+	}
+
+	reason := fmt.Sprintf("approval timed out after %s", toolCall.ApprovalExpiresAt.Sub(toolCall.StartedAt))
+
+	// A decision recorded at the same moment wins over the timeout
+	if err := models.Default().Tools.SetCallApproval(toolCall.UUID, models.ApprovalExpired); err != nil {
+		select {
+		case d := <-decision:
+			return deniedReason(d), d.approved
+		case <-time.After(5 * time.Second):
+			return reason, false
+		}
+	}
+
+	return reason, false
+}
+
+func deniedReason(d approvalDecision) string {
+	if d.approved {
+		return ""
+	}
+	if d.reason != "" {
+		return "denied by user: " + d.reason
+	}
+
+	return "denied by user"
+}
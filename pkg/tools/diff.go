@@ -0,0 +1,182 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the LCS table, larger changes are shown as a full replacement
+const maxDiffCells = 4_000_000
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-', '+'
+	text string
+}
+
+// unifiedDiff returns the unified diff turning before into after, empty when they are equal
+func unifiedDiff(name string, before string, after string) string {
+	ops := diffLines(splitLines(before), splitLines(after))
+	return formatUnified(name, ops)
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func diffLines(a []string, b []string) []diffOp {
+	// Only the part between the common prefix and suffix needs the LCS table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	return ops
+}
+
+func diffMiddle(a []string, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return ops
+}
+
+// formatUnified groups the operations into hunks with diffContext lines of context
+func formatUnified(name string, ops []diffOp) string {
+	// oldBefore[k] and newBefore[k] count the lines preceding operation k
+	oldBefore := make([]int, len(ops)+1)
+	newBefore := make([]int, len(ops)+1)
+	for k, op := range ops {
+		oldBefore[k+1] = oldBefore[k]
+		newBefore[k+1] = newBefore[k]
+		if op.kind != '+' {
+			oldBefore[k+1]++
+		}
+		if op.kind != '-' {
+			newBefore[k+1]++
+		}
+	}
+
+	var out strings.Builder
+	prevEnd := 0
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		start := max(i-diffContext, prevEnd)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = run
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+		}
+
+		oldCount := oldBefore[end] - oldBefore[start]
+		newCount := newBefore[end] - newBefore[start]
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldBefore[start], oldCount), hunkRange(newBefore[start], newCount))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.text)
+			out.WriteByte('\n')
+		}
+
+		i = end
+		prevEnd = end
+	}
+
+	return out.String()
+}
+
+// hunkRange formats a hunk range, an empty range points at the line before it
+func hunkRange(before int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
@@ -0,0 +1,518 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"wisdomizer/pkg/llm"
+)
+
+// FileTools contains tools for file operations
+var FileTools = []llm.Tool{
+	{
+		Name:        "read_file",
+		Description: "Read content from a file, optionally only a range of lines",
+		InputSchema: llm.JSONSchema{
+			Type: "object",
+			Properties: map[string]llm.Property{
+				"path": {
+					Type:        "string",
+					Description: "Path to the file to read, relative to the workspace or absolute inside it",
+				},
+				"start_line": {
+					Type:        "integer",
+					Description: "First line to read, starting at 1",
+				},
+				"end_line": {
+					Type:        "integer",
+					Description: "Last line to read, inclusive, defaults to the end of the file",
+				},
+			},
+			Required: []string{"path"},
+		},
+	},
+	{
+		Name:        "write_file",
+		Description: "Write content to a file",
+		InputSchema: llm.JSONSchema{
+			Type: "object",
+			Properties: map[string]llm.Property{
+				"path": {
+					Type:        "string",
+					Description: "Path to the file to write, relative to the workspace or absolute inside it",
+				},
+				"content": {
+					Type:        "string",
+					Description: "Content to write to the file",
+				},
+			},
+			Required: []string{"path", "content"},
+		},
+	},
+	{
+		Name:        "list_dir",
+		Description: "List the entries of a directory",
+		InputSchema: llm.JSONSchema{
+			Type: "object",
+			Properties: map[string]llm.Property{
+				"path": {
+					Type:        "string",
+					Description: "Directory to list, defaults to the workspace",
+				},
+				"pattern": {
+					Type:        "string",
+					Description: "Glob such as *.go, matched against the name, or the relative path when it contains a slash",
+				},
+				"recursive": {
+					Type:        "boolean",
+					Description: "List subdirectories too",
+				},
+			},
+		},
+	},
+	{
+		Name:        "search_files",
+		Description: "Search file contents with a regular expression and return the matching lines with their line numbers",
+		InputSchema: llm.JSONSchema{
+			Type: "object",
+			Properties: map[string]llm.Property{
+				"pattern": {
+					Type:        "string",
+					Description: "Regular expression in Go syntax",
+				},
+				"path": {
+					Type:        "string",
+					Description: "File or directory to search, defaults to the workspace",
+				},
+				"glob": {
+					Type:        "string",
+					Description: "Only search files whose name matches this glob, such as *.go",
+				},
+			},
+			Required: []string{"pattern"},
+		},
+	},
+	{
+		Name:        "stat_file",
+		Description: "Get the type, size, permissions and modification time of a file or directory",
+		InputSchema: llm.JSONSchema{
+			Type: "object",
+			Properties: map[string]llm.Property{
+				"path": {
+					Type:        "string",
+					Description: "Path to the file or directory",
+				},
+			},
+			Required: []string{"path"},
+		},
+	},
+	{
+		Name:        "apply_patch",
+		Description: "Change a file by applying a unified diff, prefer this over write_file for small edits",
+		InputSchema: llm.JSONSchema{
+			Type: "object",
+			Properties: map[string]llm.Property{
+				"path": {
+					Type:        "string",
+					Description: "Path to the file to patch, it is created when the patch starts from an empty file",
+				},
+				"patch": {
+					Type:        "string",
+					Description: "Unified diff with @@ hunks and a few lines of context, --- and +++ headers are ignored",
+				},
+			},
+			Required: []string{"path", "patch"},
+		},
+	},
+	{
+		Name:        "move_file",
+		Description: "Rename or move a file or directory",
+		InputSchema: llm.JSONSchema{
+			Type: "object",
+			Properties: map[string]llm.Property{
+				"path": {
+					Type:        "string",
+					Description: "Path to move",
+				},
+				"new_path": {
+					Type:        "string",
+					Description: "Destination path, it must not exist yet",
+				},
+			},
+			Required: []string{"path", "new_path"},
+		},
+	},
+	{
+		Name:        "delete_file",
+		Description: "Delete a file or an empty directory",
+		InputSchema: llm.JSONSchema{
+			Type: "object",
+			Properties: map[string]llm.Property{
+				"path": {
+					Type:        "string",
+					Description: "Path to delete",
+				},
+			},
+			Required: []string{"path"},
+		},
+	},
+}
+
+// FileToolInput represents the input for file tools
+type FileToolInput struct {
+	Path      string `json:"path"`
+	Content   string `json:"content,omitempty"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	Pattern   string `json:"pattern,omitempty"` // glob for list_dir, regular expression for search_files
+	Glob      string `json:"glob,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+	Patch     string `json:"patch,omitempty"`
+	NewPath   string `json:"new_path,omitempty"`
+}
+
+// FileToolOutput represents the output from file tools
+type FileToolOutput struct {
+	Success    bool          `json:"success"`
+	Content    string        `json:"content,omitempty"`
+	StartLine  int           `json:"start_line,omitempty"`
+	EndLine    int           `json:"end_line,omitempty"`
+	TotalLines int           `json:"total_lines,omitempty"`
+	Entries    []FileEntry   `json:"entries,omitempty"`
+	Matches    []SearchMatch `json:"matches,omitempty"`
+	Info       *FileEntry    `json:"info,omitempty"`
+	Truncated  bool          `json:"truncated,omitempty"` // set when a limit cut Entries or Matches short
+	Error      string        `json:"error,omitempty"`
+	Code       string        `json:"code,omitempty"` // set with Error
+}
+
+// FileEntry represents a file or directory in the workspace
+type FileEntry struct {
+	Path     string    `json:"path"`
+	Type     string    `json:"type"` // file, dir or symlink
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode,omitempty"`
+	Writable bool      `json:"writable,omitempty"`
+	ModTime  time.Time `json:"mod_time"`
+}
+
+func init() {
+	specs := map[string]Spec{
+		"read_file":    {Handler: handleReadFile},
+		"write_file":   {Handler: handleWriteFile, Mutating: true, Preview: previewWriteFile},
+		"list_dir":     {Handler: handleListDir},
+		"search_files": {Handler: handleSearchFiles},
+		"stat_file":    {Handler: handleStatFile},
+		"apply_patch":  {Handler: handleApplyPatch, Mutating: true, Preview: previewApplyPatch},
+		"move_file":    {Handler: handleMoveFile, Mutating: true, Preview: previewMoveFile},
+		"delete_file":  {Handler: handleDeleteFile, Mutating: true, Preview: previewDeleteFile},
+	}
+
+	for _, tool := range FileTools {
+		spec := specs[tool.Name]
+		spec.Definition = tool
+		Register(spec)
+	}
+}
+
+// handleReadFile processes read_file tool requests
+func handleReadFile(call Call) (json.RawMessage, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid input: %v", err))
+	}
+
+	path, err := call.Workspace.Resolve(input.Path, false)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to read file: %v", err))
+	}
+
+	if limit := call.Workspace.maxFileSize(path); info.Size() > limit {
+		return createErrorOutput(CodeFileTooLarge, fmt.Sprintf("file is %d bytes, the limit is %d bytes", info.Size(), limit))
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to read file: %v", err))
+	}
+
+	if input.StartLine == 0 && input.EndLine == 0 {
+		return createOutput(FileToolOutput{
+			Success: true,
+			Content: string(content),
+		})
+	}
+
+	lines := splitLines(string(content))
+	start, end := input.StartLine, input.EndLine
+	if start == 0 {
+		start = 1
+	}
+	if end == 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start < 1 || start > len(lines) || end < start {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid line range %d-%d, the file has %d lines", start, end, len(lines)))
+	}
+
+	return createOutput(FileToolOutput{
+		Success:    true,
+		Content:    strings.Join(lines[start-1:end], "\n") + "\n",
+		StartLine:  start,
+		EndLine:    end,
+		TotalLines: len(lines),
+	})
+}
+
+// handleWriteFile processes write_file tool requests
+func handleWriteFile(call Call) (json.RawMessage, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid input: %v", err))
+	}
+
+	path, err := call.Workspace.Resolve(input.Path, true)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	if limit := call.Workspace.maxFileSize(path); int64(len(input.Content)) > limit {
+		return createErrorOutput(CodeFileTooLarge, fmt.Sprintf("content is %d bytes, the limit is %d bytes", len(input.Content), limit))
+	}
+
+	// Create parent directories if they don't exist
+	dirPath := filepath.Dir(path)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to create directories: %v", err))
+	}
+
+	if err := os.WriteFile(path, []byte(input.Content), 0644); err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to write file: %v", err))
+	}
+
+	output := FileToolOutput{
+		Success: true,
+	}
+
+	outputBytes, err := json.Marshal(output)
+	if err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to marshal output: %v", err))
+	}
+
+	return outputBytes, nil
+}
+
+// previewWriteFile shows a write_file call as a diff against the current file content
+func previewWriteFile(call Call) (string, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return "", err
+	}
+
+	path, err := call.Workspace.Resolve(input.Path, true)
+	if err != nil {
+		return "", err
+	}
+
+	var before []byte
+	if info, err := os.Stat(path); err == nil && info.Size() <= call.Workspace.maxFileSize(path) {
+		before, err = os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return unifiedDiff(input.Path, string(before), input.Content), nil
+}
+
+// handleStatFile processes stat_file tool requests
+func handleStatFile(call Call) (json.RawMessage, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid input: %v", err))
+	}
+
+	path, err := call.Workspace.Resolve(input.Path, false)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to stat file: %v", err))
+	}
+
+	entry := newFileEntry(input.Path, info)
+	entry.Mode = info.Mode().String()
+	entry.Writable = call.Workspace.rootOf(path).Writable
+
+	return createOutput(FileToolOutput{
+		Success: true,
+		Info:    &entry,
+	})
+}
+
+// handleMoveFile processes move_file tool requests
+func handleMoveFile(call Call) (json.RawMessage, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid input: %v", err))
+	}
+
+	source, err := call.Workspace.ResolveEntry(input.Path, true)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	destination, err := call.Workspace.ResolveEntry(input.NewPath, true)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	if call.Workspace.isRoot(source) {
+		return createErrorOutput(CodeReadOnly, "a workspace directory cannot be moved")
+	}
+
+	if _, err := os.Lstat(source); err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to move file: %v", err))
+	}
+
+	if _, err := os.Lstat(destination); err == nil {
+		return createErrorOutput(CodeAlreadyExists, fmt.Sprintf("destination already exists: %s", input.NewPath))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to create directories: %v", err))
+	}
+
+	if err := os.Rename(source, destination); err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to move file: %v", err))
+	}
+
+	return createOutput(FileToolOutput{Success: true})
+}
+
+// handleDeleteFile processes delete_file tool requests
+func handleDeleteFile(call Call) (json.RawMessage, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid input: %v", err))
+	}
+
+	path, err := call.Workspace.ResolveEntry(input.Path, true)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	if call.Workspace.isRoot(path) {
+		return createErrorOutput(CodeReadOnly, "a workspace directory cannot be deleted")
+	}
+
+	// os.Remove refuses directories that still have entries
+	if err := os.Remove(path); err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to delete file: %v", err))
+	}
+
+	return createOutput(FileToolOutput{Success: true})
+}
+
+// previewMoveFile describes a move_file call
+func previewMoveFile(call Call) (string, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("move %s to %s", input.Path, input.NewPath), nil
+}
+
+// previewDeleteFile describes a delete_file call, showing the content that will be lost
+func previewDeleteFile(call Call) (string, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return "", err
+	}
+
+	path, err := call.Workspace.ResolveEntry(input.Path, true)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.Mode().IsRegular() || info.Size() > call.Workspace.maxFileSize(path) {
+		return fmt.Sprintf("delete %s", input.Path), nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return unifiedDiff(input.Path, string(content), ""), nil
+}
+
+// newFileEntry describes a file found at path
+func newFileEntry(path string, info os.FileInfo) FileEntry {
+	entry := FileEntry{
+		Path:    path,
+		Type:    "file",
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		entry.Type = "symlink"
+	case info.IsDir():
+		entry.Type = "dir"
+	}
+
+	return entry
+}
+
+// createOutput creates a JSON output
+func createOutput(output FileToolOutput) (json.RawMessage, error) {
+	outputBytes, err := json.Marshal(output)
+	if err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to marshal output: %v", err))
+	}
+
+	return outputBytes, nil
+}
+
+// createErrorOutput creates a JSON error output
+func createErrorOutput(code string, errorMsg string) (json.RawMessage, error) {
+	output := FileToolOutput{
+		Success: false,
+		Error:   errorMsg,
+		Code:    code,
+	}
+
+	outputBytes, err := json.Marshal(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal error output: %v", err)
+	}
+
+	return outputBytes, nil
+}
+
+// createWorkspaceErrorOutput creates a JSON error output for a rejected path
+func createWorkspaceErrorOutput(err error) (json.RawMessage, error) {
+	if workspaceErr, ok := err.(*WorkspaceError); ok {
+		return createErrorOutput(workspaceErr.Code, workspaceErr.Message)
+	}
+
+	return createErrorOutput(CodeIOError, err.Error())
+}
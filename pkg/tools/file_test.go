@@ -0,0 +1,60 @@
+package tools
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// runFileTool calls a tool handler on workspace and decodes its output
+func runFileTool(t *testing.T, handler Handler, workspace *Workspace, input FileToolInput) FileToolOutput {
+	t.Helper()
+
+	raw, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := handler(Call{Input: raw, Workspace: workspace})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output FileToolOutput
+	if err := json.Unmarshal(result, &output); err != nil {
+		t.Fatal(err)
+	}
+
+	return output
+}
+
+func TestMoveAndDeleteSymlinks(t *testing.T) {
+	workspace, root, outside := newTestWorkspace(t)
+
+	output := runFileTool(t, handleMoveFile, workspace, FileToolInput{Path: "secret.txt", NewPath: "src/secret-link.txt"})
+	if !output.Success {
+		t.Fatalf("move_file = %+v", output)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "src", "secret-link.txt")); err != nil || target != filepath.Join(outside, "secret.txt") {
+		t.Errorf("moved link = %q, %v", target, err)
+	}
+
+	output = runFileTool(t, handleDeleteFile, workspace, FileToolInput{Path: "src/secret-link.txt"})
+	if !output.Success {
+		t.Fatalf("delete_file = %+v", output)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "src", "secret-link.txt")); !os.IsNotExist(err) {
+		t.Errorf("deleted link still exists: %v", err)
+	}
+
+	output = runFileTool(t, handleDeleteFile, workspace, FileToolInput{Path: "escape"})
+	if !output.Success {
+		t.Fatalf("delete_file of a directory link = %+v", output)
+	}
+
+	// The targets outside the workspace are untouched
+	if content, err := os.ReadFile(filepath.Join(outside, "secret.txt")); err != nil || string(content) != "secret\n" {
+		t.Errorf("target = %q, %v", content, err)
+	}
+}
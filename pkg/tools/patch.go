@@ -0,0 +1,240 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// patchHunk represents one @@ section of a unified diff
+type patchHunk struct {
+	oldStart int
+	oldLines []string // context and removed lines
+	newLines []string // context and added lines
+}
+
+// handleApplyPatch processes apply_patch tool requests
+func handleApplyPatch(call Call) (json.RawMessage, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid input: %v", err))
+	}
+
+	path, err := call.Workspace.Resolve(input.Path, true)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	limit := call.Workspace.maxFileSize(path)
+	before, after, err := patchFile(path, input.Patch, limit)
+	if err != nil {
+		return createErrorOutput(patchErrorCode(err), err.Error())
+	}
+
+	if int64(len(after)) > limit {
+		return createErrorOutput(CodeFileTooLarge, fmt.Sprintf("patched file is %d bytes, the limit is %d bytes", len(after), limit))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to create directories: %v", err))
+	}
+
+	if err := os.WriteFile(path, []byte(after), 0644); err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to write file: %v", err))
+	}
+
+	// Echo the applied change, fuzzy hunk placement may differ from the line numbers in the patch
+	return createOutput(FileToolOutput{
+		Success: true,
+		Content: unifiedDiff(input.Path, before, after),
+	})
+}
+
+// previewApplyPatch shows an apply_patch call as the diff it will actually make,
+// falling back to the patch itself when it does not apply
+func previewApplyPatch(call Call) (string, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return "", err
+	}
+
+	path, err := call.Workspace.Resolve(input.Path, true)
+	if err != nil {
+		return "", err
+	}
+
+	before, after, err := patchFile(path, input.Patch, call.Workspace.maxFileSize(path))
+	if err != nil {
+		return input.Patch, nil
+	}
+
+	return unifiedDiff(input.Path, before, after), nil
+}
+
+// patchError represents a patch that does not apply, as opposed to a failure to read the file
+type patchError struct {
+	message string
+}
+
+func (e *patchError) Error() string {
+	return e.message
+}
+
+func patchErrorCode(err error) string {
+	switch e := err.(type) {
+	case *patchError:
+		return CodePatchFailed
+	case *WorkspaceError:
+		return e.Code
+	default:
+		return CodeIOError
+	}
+}
+
+// patchFile returns the content of the file at path before and after applying patch,
+// a missing file is patched as empty
+func patchFile(path string, patch string, maxSize int64) (string, string, error) {
+	hunks, err := parsePatch(patch)
+	if err != nil {
+		return "", "", err
+	}
+
+	var before []byte
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		return "", "", &WorkspaceError{Code: CodeInvalidInput, Message: "path is a directory"}
+	case err == nil && info.Size() > maxSize:
+		return "", "", &WorkspaceError{Code: CodeFileTooLarge, Message: fmt.Sprintf("file is %d bytes, the limit is %d bytes", info.Size(), maxSize)}
+	case err == nil:
+		before, err = os.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read file: %v", err)
+		}
+	case !os.IsNotExist(err):
+		return "", "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	after, err := applyPatch(string(before), hunks)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(before), after, nil
+}
+
+// parsePatch parses the hunks of a single file unified diff, lines before the first hunk are ignored
+func parsePatch(patch string) ([]patchHunk, error) {
+	var hunks []patchHunk
+	for _, line := range strings.Split(strings.TrimSuffix(patch, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+
+		if strings.HasPrefix(line, "@@") {
+			var oldStart int
+			if _, err := fmt.Sscanf(line, "@@ -%d", &oldStart); err != nil {
+				return nil, &patchError{message: fmt.Sprintf("invalid hunk header: %s", line)}
+			}
+			hunks = append(hunks, patchHunk{oldStart: oldStart})
+			continue
+		}
+
+		if len(hunks) == 0 || strings.HasPrefix(line, "\\") {
+			continue
+		}
+
+		hunk := &hunks[len(hunks)-1]
+		switch {
+		case line == "":
+			// Editors often strip the trailing space of an empty context line
+			hunk.oldLines = append(hunk.oldLines, "")
+			hunk.newLines = append(hunk.newLines, "")
+		case line[0] == ' ':
+			hunk.oldLines = append(hunk.oldLines, line[1:])
+			hunk.newLines = append(hunk.newLines, line[1:])
+		case line[0] == '-':
+			hunk.oldLines = append(hunk.oldLines, line[1:])
+		case line[0] == '+':
+			hunk.newLines = append(hunk.newLines, line[1:])
+		default:
+			return nil, &patchError{message: fmt.Sprintf("invalid patch line: %s", line)}
+		}
+	}
+
+	if len(hunks) == 0 {
+		return nil, &patchError{message: "patch contains no @@ hunks"}
+	}
+
+	return hunks, nil
+}
+
+// applyPatch applies the hunks in order. A hunk whose line number is off is placed at the
+// closest position where its context matches.
+func applyPatch(content string, hunks []patchHunk) (string, error) {
+	lines := splitLines(content)
+
+	var out []string
+	pos := 0
+	for i, hunk := range hunks {
+		at := findHunk(lines, hunk, pos)
+		if at < 0 {
+			return "", &patchError{message: fmt.Sprintf("hunk %d does not match the file near line %d, read the file again and retry", i+1, hunk.oldStart)}
+		}
+
+		out = append(out, lines[pos:at]...)
+		out = append(out, hunk.newLines...)
+		pos = at + len(hunk.oldLines)
+	}
+	out = append(out, lines[pos:]...)
+
+	if len(out) == 0 {
+		return "", nil
+	}
+
+	// A file without a final newline keeps lacking one
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		return strings.Join(out, "\n"), nil
+	}
+
+	return strings.Join(out, "\n") + "\n", nil
+}
+
+// findHunk returns the index at or after from where the old lines of hunk start, -1 if they do not occur
+func findHunk(lines []string, hunk patchHunk, from int) int {
+	// A pure insertion goes after line oldStart
+	if len(hunk.oldLines) == 0 {
+		return min(max(hunk.oldStart, from), len(lines))
+	}
+
+	expected := hunk.oldStart - 1
+	best := -1
+	for at := from; at+len(hunk.oldLines) <= len(lines); at++ {
+		if !linesEqual(lines[at:at+len(hunk.oldLines)], hunk.oldLines) {
+			continue
+		}
+		if best < 0 || abs(at-expected) < abs(best-expected) {
+			best = at
+		}
+	}
+
+	return best
+}
+
+func linesEqual(a []string, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
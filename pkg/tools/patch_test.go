@@ -0,0 +1,186 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		patch   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "exact position",
+			content: "one\ntwo\nthree\n",
+			patch:   "@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n",
+			want:    "one\nTWO\nthree\n",
+		},
+		{
+			name:    "header and file names are ignored",
+			content: "one\ntwo\n",
+			patch:   "--- a/f.txt\n+++ b/f.txt\n@@ -1,2 +1,2 @@\n-one\n+ONE\n two\n",
+			want:    "ONE\ntwo\n",
+		},
+		{
+			name:    "line number off",
+			content: "a\nb\nc\nd\ne\n",
+			patch:   "@@ -40,2 +40,2 @@\n c\n-d\n+D\n",
+			want:    "a\nb\nc\nD\ne\n",
+		},
+		{
+			name:    "repeated context goes to the closest match",
+			content: "x\ny\nx\ny\nx\ny\n",
+			patch:   "@@ -3,2 +3,2 @@\n x\n-y\n+Y\n",
+			want:    "x\ny\nx\nY\nx\ny\n",
+		},
+		{
+			name:    "repeated context near the end",
+			content: "x\ny\nx\ny\nx\ny\n",
+			patch:   "@@ -6,2 +6,2 @@\n x\n-y\n+Y\n",
+			want:    "x\ny\nx\ny\nx\nY\n",
+		},
+		{
+			name:    "no trailing newline",
+			content: "a\nb",
+			patch:   "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+B\n\\ No newline at end of file\n",
+			want:    "a\nB",
+		},
+		{
+			name:    "empty context line with its space stripped",
+			content: "a\n\nb\n",
+			patch:   "@@ -1,3 +1,3 @@\n a\n\n-b\n+B\n",
+			want:    "a\n\nB\n",
+		},
+		{
+			name:    "multiple hunks",
+			content: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			patch:   "@@ -1,2 +1,3 @@\n 1\n+1.5\n 2\n@@ -6,2 +7,1 @@\n-6\n 7\n",
+			want:    "1\n1.5\n2\n3\n4\n5\n7\n8\n",
+		},
+		{
+			name:    "pure insertion",
+			content: "a\nb\n",
+			patch:   "@@ -1,0 +2 @@\n+inserted\n",
+			want:    "a\ninserted\nb\n",
+		},
+		{
+			name:    "new file",
+			content: "",
+			patch:   "@@ -0,0 +1,2 @@\n+first\n+second\n",
+			want:    "first\nsecond\n",
+		},
+		{
+			name:    "removing every line",
+			content: "a\n",
+			patch:   "@@ -1 +0,0 @@\n-a\n",
+			want:    "",
+		},
+		{
+			name:    "context that does not match",
+			content: "a\nb\nc\n",
+			patch:   "@@ -1,2 +1,2 @@\n a\n-x\n+y\n",
+			wantErr: true,
+		},
+		{
+			name:    "hunks out of order",
+			content: "a\nb\nc\n",
+			patch:   "@@ -3 +3 @@\n-c\n+C\n@@ -1 +1 @@\n-a\n+A\n",
+			wantErr: true,
+		},
+		{
+			name:    "no hunks",
+			content: "a\n",
+			patch:   "-a\n+b\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid line",
+			content: "a\n",
+			patch:   "@@ -1 +1 @@\n*a\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks, err := parsePatch(tt.patch)
+			var got string
+			if err == nil {
+				got, err = applyPatch(tt.content, hunks)
+			}
+
+			if tt.wantErr {
+				var patchErr *patchError
+				if !errors.As(err, &patchErr) {
+					t.Fatalf("applyPatch() = %q, %v, want a patch error", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("applyPatch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleApplyPatch(t *testing.T) {
+	workspace, root, _ := newTestWorkspace(t)
+	writeTestFile(t, filepath.Join(root, "docs", "readme.md"), "docs\n")
+
+	tests := []struct {
+		name  string
+		input FileToolInput
+		code  string // "" when the patch applies
+		want  string // content of notes.txt afterwards
+	}{
+		{
+			name:  "failing hunk leaves the file untouched",
+			input: FileToolInput{Path: "notes.txt", Patch: "@@ -1 +1 @@\n-notes\n+NOTES\n@@ -5 +5 @@\n-missing\n+gone\n"},
+			code:  CodePatchFailed,
+			want:  "notes\n",
+		},
+		{
+			name:  "read-only root",
+			input: FileToolInput{Path: "docs/readme.md", Patch: "@@ -1 +1 @@\n-docs\n+DOCS\n"},
+			code:  CodeReadOnly,
+			want:  "notes\n",
+		},
+		{
+			name:  "outside the workspace",
+			input: FileToolInput{Path: "escape/secret.txt", Patch: "@@ -1 +1 @@\n-secret\n+leaked\n"},
+			code:  CodeOutsideWorkspace,
+			want:  "notes\n",
+		},
+		{
+			name:  "applies",
+			input: FileToolInput{Path: "notes.txt", Patch: "@@ -1 +1,2 @@\n notes\n+more notes\n"},
+			want:  "notes\nmore notes\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := runFileTool(t, handleApplyPatch, workspace, tt.input)
+			if output.Success != (tt.code == "") || output.Code != tt.code {
+				t.Errorf("apply_patch = %+v, want code %q", output, tt.code)
+			}
+
+			content, err := os.ReadFile(filepath.Join(root, "notes.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("notes.txt = %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
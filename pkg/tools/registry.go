@@ -0,0 +1,254 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"wisdomizer/models"
+	"wisdomizer/pkg/llm"
+
+	"github.com/google/uuid"
+)
+
+// Call represents a single tool invocation
+type Call struct {
+	ID        string          `json:"id"` // tool_use id assigned by the provider
+	ChatID    int             `json:"chat_id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	Workspace *Workspace      `json:"workspace"`
+}
+
+// Handler executes a tool call and returns its JSON encoded output
+type Handler func(call Call) (json.RawMessage, error)
+
+// OutputError is a tool call whose output reports a failure, such as {"success":false,...}.
+// The output is its message, so the model still reads the error code.
+type OutputError struct {
+	Output json.RawMessage
+}
+
+func (e *OutputError) Error() string {
+	return string(e.Output)
+}
+
+// Spec describes a Go tool
+type Spec struct {
+	Definition llm.Tool
+	Handler    Handler
+	// Mutating tools change state outside the chat and wait for user approval before running
+	Mutating bool
+	// Preview describes the proposed change to the user, such as a diff, it is optional
+	Preview func(call Call) (string, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Spec{}
+)
+
+// Register makes a Go tool available, tools call it from their init function
+func Register(spec Spec) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry[spec.Definition.Name] = spec
+}
+
+// Definitions returns all registered tools ordered by name
+func Definitions() []llm.Tool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	definitions := make([]llm.Tool, 0, len(registry))
+	for _, spec := range registry {
+		definitions = append(definitions, spec.Definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Name < definitions[j].Name
+	})
+
+	return definitions
+}
+
+// Lookup returns the spec registered for name
+func Lookup(name string) (Spec, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	spec, ok := registry[name]
+	return spec, ok
+}
+
+// IsRegistered reports whether a Go implementation exists for name
+func IsRegistered(name string) bool {
+	_, ok := Lookup(name)
+	return ok
+}
+
+// Sync stores every registered tool in the tools table, updating changed descriptions and schemas
+func Sync() error {
+	store := models.Default()
+
+	for _, definition := range Definitions() {
+		schema, err := json.Marshal(definition.InputSchema)
+		if err != nil {
+			return fmt.Errorf("failed to marshal schema of %s: %v", definition.Name, err)
+		}
+
+		existing, err := store.Tools.GetByName(definition.Name)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			err = store.Tools.Create(&models.Tool{
+				UUID:        uuid.New().String(),
+				Name:        definition.Name,
+				Description: definition.Description,
+				Schema:      string(schema),
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if existing.Description != definition.Description || existing.Schema != string(schema) {
+			existing.Description = definition.Description
+			existing.Schema = string(schema)
+			if err := store.Tools.Update(existing); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ForChat returns the tools enabled on a chat and a handler that only runs those tools.
+// Every invocation is recorded as a tool_calls row of the assistant message messageID.
+// Mutating tools block until the call is approved or denied, onApproval is called with the
+// pending row so the user can be asked. Cancelling the context of the call refuses it while it waits.
+func ForChat(chatID int, messageID int, onApproval func(toolCall models.ToolCall)) ([]llm.Tool, llm.ToolHandler, error) {
+	store := models.Default()
+	enabled, err := store.Tools.GetByChatID(chatID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	workspace, err := LoadWorkspace(chatID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var definitions []llm.Tool
+	toolIDs := map[string]int{}
+	for _, tool := range enabled {
+		// Rows without a Go implementation are left over from removed tools
+		spec, ok := Lookup(tool.Name)
+		if !ok {
+			continue
+		}
+		definitions = append(definitions, spec.Definition)
+		toolIDs[tool.Name] = tool.ID
+	}
+
+	handler := func(ctx context.Context, block llm.ContentBlock) (json.RawMessage, error) {
+		toolID, ok := toolIDs[block.Name]
+		if !ok {
+			return nil, fmt.Errorf("tool not enabled for this chat: %s", block.Name)
+		}
+
+		spec, ok := Lookup(block.Name)
+		if !ok {
+			return nil, fmt.Errorf("unknown tool: %s", block.Name)
+		}
+
+		call := Call{
+			ID:        block.ToolCallID,
+			ChatID:    chatID,
+			Name:      block.Name,
+			Input:     block.Input,
+			Workspace: workspace,
+		}
+
+		toolCall := &models.ToolCall{
+			UUID:      uuid.New().String(),
+			MessageID: messageID,
+			ToolID:    toolID,
+			ToolName:  block.Name,
+			Input:     string(block.Input),
+			StartedAt: time.Now(),
+		}
+
+		var decision chan approvalDecision
+		if spec.Mutating {
+			toolCall.Approval = models.ApprovalRequired
+			toolCall.ApprovalExpiresAt = toolCall.StartedAt.Add(approvalTimeout())
+			if spec.Preview != nil {
+				if preview, err := spec.Preview(call); err == nil {
+					toolCall.Preview = preview
+				}
+			}
+
+			// Listen before the row exists, so an early decision is not lost
+			decision = awaitApproval(toolCall.UUID)
+			defer forgetApproval(toolCall.UUID)
+		}
+
+		if err := store.Tools.CreateCall(toolCall); err != nil {
+			return nil, err
+		}
+
+		if spec.Mutating {
+			if onApproval != nil {
+				onApproval(*toolCall)
+			}
+
+			if reason, approved := waitForApproval(ctx, toolCall, decision); !approved {
+				if err := store.Tools.UpdateCallResult(toolCall.UUID, reason, models.ToolCallFailed); err != nil {
+					return nil, err
+				}
+				return nil, fmt.Errorf("%s", reason)
+			}
+		}
+
+		output, err := execute(spec, call)
+
+		status := models.ToolCallCompleted
+		result := string(output)
+		if err != nil {
+			status = models.ToolCallFailed
+			result = err.Error()
+		}
+
+		if updateErr := store.Tools.UpdateCallResult(toolCall.UUID, result, status); updateErr != nil {
+			return nil, updateErr
+		}
+
+		return output, err
+	}
+
+	return definitions, handler, nil
+}
+
+// execute runs the handler of spec, output reporting a failure is returned as an *OutputError
+func execute(spec Spec, call Call) (json.RawMessage, error) {
+	output, err := spec.Handler(call)
+	if err != nil {
+		return output, err
+	}
+
+	var result struct {
+		Success *bool `json:"success"`
+	}
+	if json.Unmarshal(output, &result) == nil && result.Success != nil && !*result.Success {
+		return output, &OutputError{Output: output}
+	}
+
+	return output, nil
+}
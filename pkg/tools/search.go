@@ -0,0 +1,223 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxListEntries bounds the entries returned by list_dir
+	maxListEntries = 1000
+	// maxSearchMatches bounds the lines returned by search_files
+	maxSearchMatches = 200
+	// maxMatchLength cuts long matching lines, such as minified files
+	maxMatchLength = 500
+)
+
+// skippedDirs are never walked into by recursive listings and searches
+var skippedDirs = map[string]bool{
+	".git": true,
+}
+
+// SearchMatch represents a line matched by search_files
+type SearchMatch struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// handleListDir processes list_dir tool requests
+func handleListDir(call Call) (json.RawMessage, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid input: %v", err))
+	}
+
+	if input.Path == "" {
+		input.Path = "."
+	}
+
+	if _, err := filepath.Match(input.Pattern, ""); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid pattern: %v", err))
+	}
+
+	dir, err := call.Workspace.Resolve(input.Path, false)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	output := FileToolOutput{Success: true, Entries: []FileEntry{}}
+
+	// Symlinks are listed but never followed, their targets may lie outside the workspace
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && (!input.Recursive || skippedDirs[d.Name()]) {
+			if globMatch(input.Pattern, rel) {
+				if !appendEntry(&output, filepath.Join(input.Path, rel), d) {
+					return fs.SkipAll
+				}
+			}
+			return fs.SkipDir
+		}
+
+		if globMatch(input.Pattern, rel) && !appendEntry(&output, filepath.Join(input.Path, rel), d) {
+			return fs.SkipAll
+		}
+
+		return nil
+	})
+	if err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to list directory: %v", err))
+	}
+
+	return createOutput(output)
+}
+
+// appendEntry adds an entry to the listing, reporting false once the listing is full
+func appendEntry(output *FileToolOutput, path string, d fs.DirEntry) bool {
+	if len(output.Entries) >= maxListEntries {
+		output.Truncated = true
+		return false
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		// The entry vanished while listing
+		return true
+	}
+
+	output.Entries = append(output.Entries, newFileEntry(path, info))
+	return true
+}
+
+// handleSearchFiles processes search_files tool requests
+func handleSearchFiles(call Call) (json.RawMessage, error) {
+	var input FileToolInput
+	if err := json.Unmarshal(call.Input, &input); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid input: %v", err))
+	}
+
+	if input.Path == "" {
+		input.Path = "."
+	}
+
+	pattern, err := regexp.Compile(input.Pattern)
+	if err != nil || input.Pattern == "" {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid pattern: %q", input.Pattern))
+	}
+
+	if _, err := filepath.Match(input.Glob, ""); err != nil {
+		return createErrorOutput(CodeInvalidInput, fmt.Sprintf("invalid glob: %v", err))
+	}
+
+	root, err := call.Workspace.Resolve(input.Path, false)
+	if err != nil {
+		return createWorkspaceErrorOutput(err)
+	}
+
+	output := FileToolOutput{Success: true, Matches: []SearchMatch{}}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != root && skippedDirs[d.Name()] {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		// Symlinks are not followed, their targets may lie outside the workspace
+		if !d.Type().IsRegular() || !globMatch(input.Glob, d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if !searchFile(&output, pattern, path, filepath.Join(input.Path, rel), call.Workspace.maxFileSize(path)) {
+			return fs.SkipAll
+		}
+
+		return nil
+	})
+	if err != nil {
+		return createErrorOutput(CodeIOError, fmt.Sprintf("failed to search files: %v", err))
+	}
+
+	return createOutput(output)
+}
+
+// searchFile appends the lines of a file matching pattern, reporting false once the results are full.
+// Large and binary files are skipped.
+func searchFile(output *FileToolOutput, pattern *regexp.Regexp, path string, name string, maxSize int64) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxSize {
+		return true
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0 {
+		return true
+	}
+
+	for i, line := range splitLines(string(content)) {
+		if !pattern.MatchString(line) {
+			continue
+		}
+
+		if len(output.Matches) >= maxSearchMatches {
+			output.Truncated = true
+			return false
+		}
+
+		if len(line) > maxMatchLength {
+			line = strings.ToValidUTF8(line[:maxMatchLength], "")
+		}
+
+		output.Matches = append(output.Matches, SearchMatch{
+			Path: name,
+			Line: i + 1,
+			Text: line,
+		})
+	}
+
+	return true
+}
+
+// globMatch matches a glob against the name of rel, or against rel itself when the glob contains a
+// slash. An empty glob matches everything.
+func globMatch(glob string, rel string) bool {
+	if glob == "" {
+		return true
+	}
+
+	name := filepath.Base(rel)
+	if strings.Contains(glob, "/") {
+		name = filepath.ToSlash(rel)
+	}
+
+	matched, _ := filepath.Match(glob, name)
+	return matched
+}
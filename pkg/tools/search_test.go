@@ -0,0 +1,153 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestHandleListDir(t *testing.T) {
+	workspace, root, _ := newTestWorkspace(t)
+	writeTestFile(t, filepath.Join(root, "src", "main.go"), "package main\n")
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, ".git", "HEAD"), "ref: refs/heads/main\n")
+
+	tests := []struct {
+		name  string
+		input FileToolInput
+		want  []string // path:type of the entries, in walk order
+		code  string
+	}{
+		{
+			name:  "root",
+			input: FileToolInput{},
+			want:  []string{".git:dir", "docs:dir", "escape:symlink", "notes.txt:file", "secret.txt:symlink", "source:symlink", "src:dir"},
+		},
+		{
+			name:  "recursive listing does not follow symlinks or enter .git",
+			input: FileToolInput{Recursive: true},
+			want:  []string{".git:dir", "docs:dir", "escape:symlink", "notes.txt:file", "secret.txt:symlink", "source:symlink", "src:dir", "src/main.go:file"},
+		},
+		{
+			name:  "glob",
+			input: FileToolInput{Recursive: true, Pattern: "*.go"},
+			want:  []string{"src/main.go:file"},
+		},
+		{
+			name:  "subdirectory through a symlink inside the root",
+			input: FileToolInput{Path: "source"},
+			want:  []string{"source/main.go:file"},
+		},
+		{
+			name:  "symlink escaping the root",
+			input: FileToolInput{Path: "escape"},
+			code:  CodeOutsideWorkspace,
+		},
+		{
+			name:  "parent traversal",
+			input: FileToolInput{Path: "../outside"},
+			code:  CodeOutsideWorkspace,
+		},
+		{
+			name:  "invalid glob",
+			input: FileToolInput{Pattern: "["},
+			code:  CodeInvalidInput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := runFileTool(t, handleListDir, workspace, tt.input)
+			if tt.code != "" {
+				if output.Success || output.Code != tt.code {
+					t.Fatalf("list_dir = %+v, want code %s", output, tt.code)
+				}
+				return
+			}
+			if !output.Success {
+				t.Fatalf("list_dir = %+v", output)
+			}
+
+			var got []string
+			for _, entry := range output.Entries {
+				got = append(got, filepath.ToSlash(entry.Path)+":"+entry.Type)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleSearchFiles(t *testing.T) {
+	workspace, root, _ := newTestWorkspace(t)
+	writeTestFile(t, filepath.Join(root, "src", "main.go"), "package main\n\nfunc secretHelper() {}\n")
+	writeTestFile(t, filepath.Join(root, "src", "blob.bin"), "secret\x00binary\n")
+	writeTestFile(t, filepath.Join(root, "docs", "small.md"), "secret\n")
+	writeTestFile(t, filepath.Join(root, "docs", "large.md"), "secret, but past the limit of the docs root\n")
+
+	tests := []struct {
+		name  string
+		input FileToolInput
+		want  []string // path:line of the matches
+		code  string
+	}{
+		{
+			name:  "skips symlinks, binary files and files over the root limit",
+			input: FileToolInput{Pattern: "secret"},
+			want:  []string{"docs/small.md:1", "src/main.go:3"},
+		},
+		{
+			name:  "glob",
+			input: FileToolInput{Pattern: "secret", Glob: "*.go"},
+			want:  []string{"src/main.go:3"},
+		},
+		{
+			name:  "subdirectory",
+			input: FileToolInput{Path: "src", Pattern: `^package \w+$`},
+			want:  []string{"src/main.go:1"},
+		},
+		{
+			name:  "symlink escaping the root",
+			input: FileToolInput{Path: "escape", Pattern: "secret"},
+			code:  CodeOutsideWorkspace,
+		},
+		{
+			name:  "absolute path outside the roots",
+			input: FileToolInput{Path: "/etc", Pattern: "root"},
+			code:  CodeOutsideWorkspace,
+		},
+		{
+			name:  "invalid pattern",
+			input: FileToolInput{Pattern: "("},
+			code:  CodeInvalidInput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := runFileTool(t, handleSearchFiles, workspace, tt.input)
+			if tt.code != "" {
+				if output.Success || output.Code != tt.code {
+					t.Fatalf("search_files = %+v, want code %s", output, tt.code)
+				}
+				return
+			}
+			if !output.Success {
+				t.Fatalf("search_files = %+v", output)
+			}
+
+			var got []string
+			for _, match := range output.Matches {
+				got = append(got, filepath.ToSlash(match.Path)+":"+strconv.Itoa(match.Line))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"wisdomizer/models"
+)
+
+// DefaultMaxFileSize bounds file tool reads and writes when TOOLS_MAX_FILE_SIZE is not set
+const DefaultMaxFileSize = 1 << 20 // 1 MiB
+
+// Error codes reported in FileToolOutput.Code
+const (
+	CodeInvalidInput     = "invalid_input"
+	CodeOutsideWorkspace = "outside_workspace"
+	CodeReadOnly         = "read_only"
+	CodeFileTooLarge     = "file_too_large"
+	CodeAlreadyExists    = "already_exists"
+	CodePatchFailed      = "patch_failed"
+	CodeIOError          = "io_error"
+)
+
+// Root represents a directory the file tools may access
+type Root struct {
+	Path        string `json:"path"`
+	Writable    bool   `json:"writable"`
+	MaxFileSize int64  `json:"max_file_size"` // 0 uses the workspace limit
+}
+
+// Workspace represents the directories the file tools of a chat may access
+type Workspace struct {
+	Roots       []Root `json:"roots"`
+	MaxFileSize int64  `json:"max_file_size"` // for roots without a limit of their own
+}
+
+// WorkspaceError represents a rejected file tool path or operation
+type WorkspaceError struct {
+	Code    string
+	Message string
+}
+
+func (e *WorkspaceError) Error() string {
+	return e.Message
+}
+
+// LoadWorkspace reads the workspace roots configured for a chat
+func LoadWorkspace(chatID int) (*Workspace, error) {
+	rows, err := models.Default().Chats.GetWorkspaceRoots(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace := &Workspace{MaxFileSize: DefaultMaxFileSize}
+	if value, err := strconv.ParseInt(os.Getenv("TOOLS_MAX_FILE_SIZE"), 10, 64); err == nil && value > 0 {
+		workspace.MaxFileSize = value
+	}
+
+	for _, row := range rows {
+		workspace.Roots = append(workspace.Roots, Root{
+			Path:        row.Path,
+			Writable:    row.Mode == models.WorkspaceReadWrite,
+			MaxFileSize: row.MaxFileSize,
+		})
+	}
+
+	return workspace, nil
+}
+
+// Resolve returns the absolute, symlink free form of path after checking that it lies inside a
+// root allowing the access. Relative paths are taken from the first root.
+func (w *Workspace) Resolve(path string, write bool) (string, error) {
+	return w.resolve(path, write, resolveSymlinks)
+}
+
+// ResolveEntry is Resolve for operations on the directory entry itself, such as a move or a delete.
+// Only the parent directory is resolved, so a symlink is moved or deleted rather than its target.
+func (w *Workspace) ResolveEntry(path string, write bool) (string, error) {
+	return w.resolve(path, write, func(path string) (string, error) {
+		parent, err := resolveSymlinks(filepath.Dir(path))
+		if err != nil {
+			return "", err
+		}
+
+		return filepath.Join(parent, filepath.Base(path)), nil
+	})
+}
+
+// resolve checks the path returned by resolveLinks against the roots
+func (w *Workspace) resolve(path string, write bool, resolveLinks func(string) (string, error)) (string, error) {
+	if path == "" {
+		return "", &WorkspaceError{Code: CodeInvalidInput, Message: "path is required"}
+	}
+
+	if w == nil || len(w.Roots) == 0 {
+		return "", &WorkspaceError{Code: CodeOutsideWorkspace, Message: "no workspace directories are configured for this topic"}
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(w.Roots[0].Path, path)
+	}
+
+	resolved, err := resolveLinks(filepath.Clean(path))
+	if err != nil {
+		return "", &WorkspaceError{Code: CodeIOError, Message: fmt.Sprintf("failed to resolve path: %v", err)}
+	}
+
+	root := w.rootOf(resolved)
+	if root == nil {
+		return "", &WorkspaceError{Code: CodeOutsideWorkspace, Message: fmt.Sprintf("path is outside the workspace: %s", path)}
+	}
+
+	if write && !root.Writable {
+		return "", &WorkspaceError{Code: CodeReadOnly, Message: fmt.Sprintf("path is in a read-only workspace: %s", path)}
+	}
+
+	return resolved, nil
+}
+
+// rootOf returns the most specific root containing path, so a read-only directory
+// inside a writable root stays read-only
+func (w *Workspace) rootOf(path string) *Root {
+	var match *Root
+	for i := range w.Roots {
+		root := &w.Roots[i]
+		if !within(root.Path, path) {
+			continue
+		}
+		if match == nil || len(root.Path) > len(match.Path) {
+			match = root
+		}
+	}
+
+	return match
+}
+
+// maxFileSize returns the size limit of the root containing a resolved path
+func (w *Workspace) maxFileSize(path string) int64 {
+	if root := w.rootOf(path); root != nil && root.MaxFileSize > 0 {
+		return root.MaxFileSize
+	}
+
+	return w.MaxFileSize
+}
+
+// isRoot reports whether path is one of the workspace directories itself
+func (w *Workspace) isRoot(path string) bool {
+	for _, root := range w.Roots {
+		if filepath.Clean(root.Path) == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// within reports whether path is root or lies below it
+func within(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveSymlinks evaluates the symlinks of the longest existing prefix of path,
+// so files that are about to be created can be checked too
+func resolveSymlinks(path string) (string, error) {
+	existing := path
+	var rest []string
+	for {
+		_, err := os.Lstat(existing)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(append([]string{resolved}, rest...)...), nil
+}
@@ -0,0 +1,217 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestWorkspace creates a writable root holding a read-only root, and a directory outside both
+func newTestWorkspace(t *testing.T) (workspace *Workspace, root string, outside string) {
+	t.Helper()
+
+	// The temporary directory may itself be reached through a symlink
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root = filepath.Join(base, "work")
+	outside = filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "docs"), filepath.Join(root, "src"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, "notes.txt"), "notes\n")
+	writeTestFile(t, filepath.Join(outside, "secret.txt"), "secret\n")
+
+	for link, target := range map[string]string{
+		"escape": outside,
+		"source": filepath.Join(root, "src"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	workspace = &Workspace{
+		Roots: []Root{
+			{Path: root, Writable: true},
+			{Path: filepath.Join(root, "docs"), MaxFileSize: 16},
+		},
+		MaxFileSize: DefaultMaxFileSize,
+	}
+
+	return workspace, root, outside
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// workspaceErrorCode returns the code of a WorkspaceError, or "" for any other error
+func workspaceErrorCode(err error) string {
+	var workspaceErr *WorkspaceError
+	if errors.As(err, &workspaceErr) {
+		return workspaceErr.Code
+	}
+
+	return ""
+}
+
+func TestResolve(t *testing.T) {
+	workspace, root, outside := newTestWorkspace(t)
+
+	tests := []struct {
+		name  string
+		path  string
+		write bool
+		want  string // resolved path, when code is empty
+		code  string
+	}{
+		{name: "relative path", path: "notes.txt", want: filepath.Join(root, "notes.txt")},
+		{name: "absolute path", path: filepath.Join(root, "notes.txt"), write: true, want: filepath.Join(root, "notes.txt")},
+		{name: "new file", path: "src/new/main.go", write: true, want: filepath.Join(root, "src", "new", "main.go")},
+		{name: "dot segments inside the root", path: "src/../notes.txt", want: filepath.Join(root, "notes.txt")},
+		{name: "empty path", path: "", code: CodeInvalidInput},
+		{name: "parent traversal", path: "../outside/secret.txt", code: CodeOutsideWorkspace},
+		{name: "traversal through a subdirectory", path: "src/../../outside/secret.txt", code: CodeOutsideWorkspace},
+		{name: "absolute path outside the roots", path: filepath.Join(outside, "secret.txt"), code: CodeOutsideWorkspace},
+		{name: "system file", path: "/etc/passwd", code: CodeOutsideWorkspace},
+		{name: "sibling with the root as prefix", path: root + "-backup/notes.txt", code: CodeOutsideWorkspace},
+		{name: "symlinked file escaping the root", path: "secret.txt", code: CodeOutsideWorkspace},
+		{name: "symlinked directory escaping the root", path: "escape/secret.txt", code: CodeOutsideWorkspace},
+		{name: "new file below an escaping symlink", path: "escape/new/file.txt", write: true, code: CodeOutsideWorkspace},
+		{name: "new file below a symlink inside the root", path: "source/new.go", write: true, want: filepath.Join(root, "src", "new.go")},
+		{name: "read in a nested read-only root", path: "docs/readme.md", want: filepath.Join(root, "docs", "readme.md")},
+		{name: "write in a nested read-only root", path: "docs/readme.md", write: true, code: CodeReadOnly},
+		{name: "write to the nested read-only root itself", path: "docs", write: true, code: CodeReadOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := workspace.Resolve(tt.path, tt.write)
+			if tt.code != "" {
+				if code := workspaceErrorCode(err); code != tt.code {
+					t.Fatalf("Resolve(%q) = %q, %v, want code %s", tt.path, got, err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%q) error = %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveEntry(t *testing.T) {
+	workspace, root, _ := newTestWorkspace(t)
+
+	tests := []struct {
+		name string
+		path string
+		want string
+		code string
+	}{
+		{name: "symlinked file stays the link", path: "secret.txt", want: filepath.Join(root, "secret.txt")},
+		{name: "symlinked directory stays the link", path: "escape", want: filepath.Join(root, "escape")},
+		{name: "entry below a symlink inside the root", path: "source/new.go", want: filepath.Join(root, "src", "new.go")},
+		{name: "entry below an escaping symlink", path: "escape/secret.txt", code: CodeOutsideWorkspace},
+		{name: "parent traversal", path: "../outside/secret.txt", code: CodeOutsideWorkspace},
+		{name: "entry in a nested read-only root", path: "docs/readme.md", code: CodeReadOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := workspace.ResolveEntry(tt.path, true)
+			if tt.code != "" {
+				if code := workspaceErrorCode(err); code != tt.code {
+					t.Fatalf("ResolveEntry(%q) = %q, %v, want code %s", tt.path, got, err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveEntry(%q) error = %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveEntry(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveWithoutRoots(t *testing.T) {
+	for _, workspace := range []*Workspace{nil, {MaxFileSize: DefaultMaxFileSize}} {
+		if _, err := workspace.Resolve("notes.txt", false); workspaceErrorCode(err) != CodeOutsideWorkspace {
+			t.Errorf("Resolve without roots error = %v, want code %s", err, CodeOutsideWorkspace)
+		}
+	}
+}
+
+func TestResolveSymlinks(t *testing.T) {
+	_, root, outside := newTestWorkspace(t)
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "existing file", path: filepath.Join(root, "notes.txt"), want: filepath.Join(root, "notes.txt")},
+		{name: "symlinked file", path: filepath.Join(root, "secret.txt"), want: filepath.Join(outside, "secret.txt")},
+		{name: "missing file", path: filepath.Join(root, "a", "b.txt"), want: filepath.Join(root, "a", "b.txt")},
+		{name: "missing file below a symlinked parent", path: filepath.Join(root, "escape", "a", "b.txt"), want: filepath.Join(outside, "a", "b.txt")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSymlinks(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSymlinks(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRootOf(t *testing.T) {
+	workspace, root, outside := newTestWorkspace(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string // "" when no root contains the path
+		maxSize int64
+	}{
+		{name: "root itself", path: root, want: root, maxSize: DefaultMaxFileSize},
+		{name: "file in the root", path: filepath.Join(root, "src", "main.go"), want: root, maxSize: DefaultMaxFileSize},
+		{name: "nested root wins", path: filepath.Join(root, "docs", "readme.md"), want: filepath.Join(root, "docs"), maxSize: 16},
+		{name: "sibling with the nested root as prefix", path: filepath.Join(root, "docs2", "a.md"), want: root, maxSize: DefaultMaxFileSize},
+		{name: "outside", path: filepath.Join(outside, "secret.txt"), maxSize: DefaultMaxFileSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := workspace.rootOf(tt.path)
+			if got == nil && tt.want != "" || got != nil && got.Path != tt.want {
+				t.Errorf("rootOf(%q) = %+v, want %q", tt.path, got, tt.want)
+			}
+			if size := workspace.maxFileSize(tt.path); size != tt.maxSize {
+				t.Errorf("maxFileSize(%q) = %d, want %d", tt.path, size, tt.maxSize)
+			}
+		})
+	}
+}
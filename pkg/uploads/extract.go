@@ -0,0 +1,461 @@
+package uploads
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Document types whose text is extracted
+const (
+	TypePDF  = "application/pdf"
+	TypeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	TypeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// maxPartSize bounds the decompressed size of a part read from a DOCX or XLSX archive
+const maxPartSize = 64 << 20 // 64 MiB
+
+// MaxExtractedSize bounds the text extracted from a document, the rest of the document is dropped
+const MaxExtractedSize = 4 << 20 // 4 MiB
+
+// IsPDF reports whether content of the given type is a PDF
+func IsPDF(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == TypePDF
+}
+
+// CanExtract reports whether the text of content of the given type is extracted
+func CanExtract(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch mediaType {
+	case TypePDF, TypeDOCX, TypeXLSX:
+		return true
+	}
+
+	return false
+}
+
+// ExtractText returns the text of a PDF, DOCX or XLSX upload, with a marker before every PDF page and
+// spreadsheet sheet, truncated to MaxExtractedSize. The text is cached next to the upload, later calls
+// read the cache. Extraction is abandoned when ctx is done.
+func ExtractText(ctx context.Context, uploadPath string, contentType string) (string, error) {
+	cachePath := uploadPath + ".txt"
+	if cached, err := os.ReadFile(cachePath); err == nil {
+		return string(cached), nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	var extract func(ctx context.Context, uploadPath string) (string, error)
+	switch mediaType {
+	case TypePDF:
+		extract = extractPDF
+	case TypeDOCX:
+		extract = extractDOCX
+	case TypeXLSX:
+		extract = extractXLSX
+	default:
+		return "", fmt.Errorf("cannot extract text from %s", contentType)
+	}
+
+	type result struct {
+		text string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		text, err := extract(ctx, uploadPath)
+		done <- result{text, err}
+	}()
+
+	var text string
+	select {
+	case <-ctx.Done():
+		return "", fmt.Errorf("failed to extract text: %v", ctx.Err())
+	case r := <-done:
+		if r.err != nil {
+			return "", r.err
+		}
+		text = truncate(r.text, MaxExtractedSize)
+	}
+
+	if err := os.WriteFile(cachePath, []byte(text), 0o644); err != nil {
+		return "", fmt.Errorf("failed to cache extracted text: %v", err)
+	}
+
+	return text, nil
+}
+
+// ReadDocument returns the text of a text upload, or the extracted text of a document, truncated to
+// MaxDocumentSize
+func ReadDocument(ctx context.Context, uploadPath string, contentType string) (string, error) {
+	if IsText(contentType) {
+		return ReadText(uploadPath)
+	}
+
+	text, err := ExtractText(ctx, uploadPath, contentType)
+	if err != nil {
+		return "", err
+	}
+
+	return truncate(text, MaxDocumentSize), nil
+}
+
+// truncate cuts text to at most size bytes on a rune boundary, marking the cut
+func truncate(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+
+	cut := size
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+
+	return text[:cut] + "\n[truncated]"
+}
+
+// PDFPages returns the number of pages of a PDF upload
+func PDFPages(uploadPath string) (pages int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pages, err = 0, fmt.Errorf("failed to read PDF: %v", r)
+		}
+	}()
+
+	file, reader, err := pdf.Open(uploadPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open PDF: %v", err)
+	}
+	defer file.Close()
+
+	return reader.NumPage(), nil
+}
+
+// extractPDF reads the text of every page, rebuilding lines and word gaps from the glyph positions.
+// It stops between pages once ctx is done or the text is longer than MaxExtractedSize.
+func extractPDF(ctx context.Context, uploadPath string) (text string, err error) {
+	// The PDF reader panics on malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("failed to read PDF: %v", r)
+		}
+	}()
+
+	file, reader, err := pdf.Open(uploadPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open PDF: %v", err)
+	}
+	defer file.Close()
+
+	var b strings.Builder
+	for i := 1; i <= reader.NumPage() && b.Len() <= MaxExtractedSize; i++ {
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("failed to read PDF: %v", err)
+		}
+
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		fmt.Fprintf(&b, "--- Page %d ---\n", i)
+		b.WriteString(pageText(page.Content().Text))
+		b.WriteString("\n\n")
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// pageText joins the glyphs of a page in content order, a glyph on another baseline starts a line and a
+// gap wider than a fraction of the font size a word
+func pageText(glyphs []pdf.Text) string {
+	var b strings.Builder
+	var previous *pdf.Text
+	for i := range glyphs {
+		glyph := &glyphs[i]
+		if previous != nil {
+			size := math.Max(previous.FontSize, 1)
+			// Fonts without widths leave W at zero, assume half an em per character
+			end := previous.X + previous.W
+			if previous.W == 0 {
+				end = previous.X + size*0.5*float64(utf8.RuneCountInString(previous.S))
+			}
+
+			// The reader also emits line breaks of its own
+			written := b.String()
+			switch {
+			case math.Abs(glyph.Y-previous.Y) > size/2:
+				if !strings.HasSuffix(written, "\n") {
+					b.WriteString("\n")
+				}
+			case glyph.X-end > size*0.15 && !strings.HasSuffix(written, " ") && !strings.HasSuffix(written, "\n") && !strings.HasPrefix(glyph.S, " "):
+				b.WriteString(" ")
+			}
+		}
+
+		b.WriteString(glyph.S)
+		previous = glyph
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// extractDOCX reads the paragraphs of a Word document, table cells are separated by tabs
+func extractDOCX(ctx context.Context, uploadPath string) (string, error) {
+	archive, err := zip.OpenReader(uploadPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open DOCX: %v", err)
+	}
+	defer archive.Close()
+
+	part, err := openPart(&archive.Reader, "word/document.xml")
+	if err != nil {
+		return "", err
+	}
+	defer part.Close()
+
+	var text []byte
+	inText, runDepth, cellDepth := false, 0, 0
+	decoder := xml.NewDecoder(io.LimitReader(part, maxPartSize))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse DOCX: %v", err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "t":
+				inText = true
+			case "r":
+				runDepth++
+			case "tab":
+				// Outside a run, w:tab is a tab stop of the paragraph properties
+				if runDepth > 0 {
+					text = append(text, '\t')
+				}
+			case "br", "cr":
+				text = append(text, '\n')
+			case "tc":
+				cellDepth++
+			}
+		case xml.EndElement:
+			switch element.Name.Local {
+			case "t":
+				inText = false
+			case "r":
+				runDepth--
+			case "p":
+				// Paragraphs inside a table cell stay on the row
+				if cellDepth > 0 {
+					text = append(text, ' ')
+				} else {
+					text = append(text, '\n')
+				}
+			case "tc":
+				cellDepth--
+				text = append(bytes.TrimRight(text, " "), '\t')
+			case "tr":
+				text = append(bytes.TrimRight(text, "\t"), '\n')
+			}
+		case xml.CharData:
+			if inText {
+				text = append(text, element...)
+			}
+		}
+	}
+
+	return strings.TrimSpace(string(text)), nil
+}
+
+// extractXLSX reads the cells of every sheet in workbook order, a row per line with tab separated cells
+func extractXLSX(ctx context.Context, uploadPath string) (string, error) {
+	archive, err := zip.OpenReader(uploadPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open XLSX: %v", err)
+	}
+	defer archive.Close()
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodePart(&archive.Reader, "xl/workbook.xml", &workbook); err != nil {
+		return "", err
+	}
+
+	var relationships struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodePart(&archive.Reader, "xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return "", err
+	}
+	targets := map[string]string{}
+	for _, relationship := range relationships.Relationships {
+		target := strings.TrimPrefix(relationship.Target, "/")
+		if !strings.HasPrefix(target, "xl/") {
+			target = path.Join("xl", target)
+		}
+		targets[relationship.ID] = target
+	}
+
+	// Workbooks without text cells have no shared strings
+	var sharedStrings struct {
+		Items []richText `xml:"si"`
+	}
+	if hasPart(&archive.Reader, "xl/sharedStrings.xml") {
+		if err := decodePart(&archive.Reader, "xl/sharedStrings.xml", &sharedStrings); err != nil {
+			return "", err
+		}
+	}
+
+	var b strings.Builder
+	for _, sheet := range workbook.Sheets {
+		target, ok := targets[sheet.ID]
+		if !ok {
+			continue
+		}
+
+		var worksheet struct {
+			Rows []struct {
+				Cells []struct {
+					Ref    string   `xml:"r,attr"`
+					Type   string   `xml:"t,attr"`
+					Value  string   `xml:"v"`
+					Inline richText `xml:"is"`
+				} `xml:"c"`
+			} `xml:"sheetData>row"`
+		}
+		if err := decodePart(&archive.Reader, target, &worksheet); err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&b, "--- Sheet: %s ---\n", sheet.Name)
+		for _, row := range worksheet.Rows {
+			var cells []string
+			for _, cell := range row.Cells {
+				value := cell.Value
+				switch cell.Type {
+				case "s":
+					if index, err := strconv.Atoi(value); err == nil && index >= 0 && index < len(sharedStrings.Items) {
+						value = sharedStrings.Items[index].String()
+					}
+				case "inlineStr":
+					value = cell.Inline.String()
+				case "b":
+					value = strconv.FormatBool(value == "1")
+				}
+
+				// Place the cell in its column, empty cells are not stored
+				if column := columnIndex(cell.Ref); column > len(cells) {
+					cells = append(cells, make([]string, column-len(cells))...)
+				}
+				cells = append(cells, strings.TrimFunc(value, unicode.IsSpace))
+			}
+
+			line := strings.TrimRight(strings.Join(cells, "\t"), "\t")
+			if line != "" {
+				b.WriteString(line + "\n")
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// richText represents a shared or inline string, either plain or made of formatted runs
+type richText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (r richText) String() string {
+	text := r.Text
+	for _, run := range r.Runs {
+		text += run.Text
+	}
+
+	return text
+}
+
+// columnIndex returns the zero based column of a cell reference such as C7, or -1 without one
+func columnIndex(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+	}
+
+	return column - 1
+}
+
+func hasPart(archive *zip.Reader, name string) bool {
+	for _, file := range archive.File {
+		if file.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func openPart(archive *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, file := range archive.File {
+		if file.Name == name {
+			part, err := file.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open %s: %v", name, err)
+			}
+			return part, nil
+		}
+	}
+
+	return nil, fmt.Errorf("missing part %s", name)
+}
+
+func decodePart(archive *zip.Reader, name string, v any) error {
+	part, err := openPart(archive, name)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	if err := xml.NewDecoder(io.LimitReader(part, maxPartSize)).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+
+	return nil
+}
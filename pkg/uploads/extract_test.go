@@ -0,0 +1,295 @@
+package uploads
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeArchive stores a DOCX or XLSX fixture made of the given parts
+func writeArchive(t *testing.T, name string, parts map[string]string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for partName, content := range parts {
+		part, err := archive.Create(partName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return writeFixture(t, name, buf.Bytes())
+}
+
+func writeFixture(t *testing.T, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// pdfFixture builds a PDF of one page per content stream, set in Helvetica
+func pdfFixture(contents ...string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // page tree, once the pages are numbered
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	var kids []string
+	for _, content := range contents {
+		page := len(objects) + 1
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents %d 0 R /Resources << /Font << /F1 3 0 R >> >> >>", page+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return []byte(b.String())
+}
+
+const docxDocument = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+	<w:body>
+		<w:p><w:r><w:t>Title</w:t></w:r></w:p>
+		<w:p>
+			<w:pPr><w:tabs><w:tab w:val="left" w:pos="2880"/><w:tab w:val="right" w:pos="5760"/></w:tabs></w:pPr>
+			<w:r><w:t>Name</w:t></w:r>
+			<w:r><w:tab/><w:t>Value</w:t></w:r>
+		</w:p>
+		<w:p>
+			<w:r><w:t xml:space="preserve">First line</w:t><w:br/><w:t>second line</w:t></w:r>
+		</w:p>
+		<w:tbl>
+			<w:tr>
+				<w:tc><w:p><w:r><w:t>A1</w:t></w:r></w:p></w:tc>
+				<w:tc><w:p><w:r><w:t>B1</w:t></w:r></w:p><w:p><w:r><w:t>more</w:t></w:r></w:p></w:tc>
+			</w:tr>
+		</w:tbl>
+		<w:p><w:r><w:t>After &amp; done</w:t></w:r></w:p>
+	</w:body>
+</w:document>`
+
+const (
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+	<sheets>
+		<sheet name="Prices" sheetId="1" r:id="rId1"/>
+		<sheet name="Notes" sheetId="2" r:id="rId2"/>
+	</sheets>
+</workbook>`
+
+	xlsxRelationships = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+	<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+	<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet2.xml"/>
+</Relationships>`
+
+	xlsxSharedStrings = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" count="3" uniqueCount="3">
+	<si><t>Item</t></si>
+	<si><t>Price</t></si>
+	<si><r><t>Red </t></r><r><rPr><b/></rPr><t>apple</t></r></si>
+</sst>`
+
+	xlsxSheet1 = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+	<sheetData>
+		<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+		<row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2"><v>1.5</v></c><c r="D2" t="b"><v>1</v></c></row>
+		<row r="3"><c r="A3" t="s"><v>7</v></c></row>
+	</sheetData>
+</worksheet>`
+
+	xlsxSheet2 = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+	<sheetData>
+		<row r="1"><c r="B1" t="inlineStr"><is><t>inline note</t></is></c></row>
+	</sheetData>
+</worksheet>`
+)
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		path        func(t *testing.T) string
+		want        string
+		wantErr     string
+	}{
+		{
+			name:        "docx with tab stops, tabs, breaks and a table",
+			contentType: TypeDOCX,
+			path: func(t *testing.T) string {
+				return writeArchive(t, "doc.docx", map[string]string{"word/document.xml": docxDocument})
+			},
+			want: "Title\nName\tValue\nFirst line\nsecond line\nA1\tB1 more\nAfter & done",
+		},
+		{
+			name:        "xlsx with shared, rich, inline and out of range strings",
+			contentType: TypeXLSX,
+			path: func(t *testing.T) string {
+				return writeArchive(t, "book.xlsx", map[string]string{
+					"xl/workbook.xml":            xlsxWorkbook,
+					"xl/_rels/workbook.xml.rels": xlsxRelationships,
+					"xl/sharedStrings.xml":       xlsxSharedStrings,
+					"xl/worksheets/sheet1.xml":   xlsxSheet1,
+					"xl/worksheets/sheet2.xml":   xlsxSheet2,
+				})
+			},
+			want: "--- Sheet: Prices ---\nItem\tPrice\nRed apple\t1.5\t\ttrue\n7\n\n--- Sheet: Notes ---\n\tinline note",
+		},
+		{
+			name:        "pdf pages",
+			contentType: TypePDF,
+			path: func(t *testing.T) string {
+				return writeFixture(t, "doc.pdf", pdfFixture(
+					"BT /F1 12 Tf 72 720 Td (Hello world) Tj 0 -20 Td (Second line) Tj ET",
+					"BT /F1 12 Tf 72 720 Td (Page two) Tj ET",
+				))
+			},
+			want: "--- Page 1 ---\nHello world\nSecond line\n\n--- Page 2 ---\nPage two",
+		},
+		{
+			name:        "pdf the reader panics on",
+			contentType: TypePDF,
+			path: func(t *testing.T) string {
+				// Td without operands
+				return writeFixture(t, "corrupt.pdf", pdfFixture("BT /F1 12 Tf Td (Hello) Tj ET"))
+			},
+			wantErr: "failed to read PDF",
+		},
+		{
+			name:        "truncated pdf",
+			contentType: TypePDF,
+			path: func(t *testing.T) string {
+				content := pdfFixture("BT /F1 12 Tf 72 720 Td (Hello) Tj ET")
+				return writeFixture(t, "truncated.pdf", content[:len(content)/2])
+			},
+			wantErr: "failed to open PDF",
+		},
+		{
+			name:        "docx that is not an archive",
+			contentType: TypeDOCX,
+			path: func(t *testing.T) string {
+				return writeFixture(t, "doc.docx", []byte("not a zip"))
+			},
+			wantErr: "failed to open DOCX",
+		},
+		{
+			name:        "docx without a document",
+			contentType: TypeDOCX,
+			path: func(t *testing.T) string {
+				return writeArchive(t, "doc.docx", map[string]string{"word/styles.xml": "<styles/>"})
+			},
+			wantErr: "missing part word/document.xml",
+		},
+		{
+			name:        "xlsx with a malformed sheet",
+			contentType: TypeXLSX,
+			path: func(t *testing.T) string {
+				return writeArchive(t, "book.xlsx", map[string]string{
+					"xl/workbook.xml":            xlsxWorkbook,
+					"xl/_rels/workbook.xml.rels": xlsxRelationships,
+					"xl/worksheets/sheet1.xml":   "<worksheet><sheetData><row>",
+				})
+			},
+			wantErr: "failed to parse xl/worksheets/sheet1.xml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path(t)
+			text, err := ExtractText(context.Background(), path, tt.contentType)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ExtractText() = %q, %v, want error %q", text, err, tt.wantErr)
+				}
+				if _, err := os.Stat(path + ".txt"); !os.IsNotExist(err) {
+					t.Errorf("failed extraction was cached: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.want {
+				t.Errorf("ExtractText() = %q, want %q", text, tt.want)
+			}
+
+			// Later calls read the cache
+			if err := os.WriteFile(path+".txt", []byte("cached"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if text, err := ExtractText(context.Background(), path, tt.contentType); err != nil || text != "cached" {
+				t.Errorf("ExtractText() from cache = %q, %v", text, err)
+			}
+		})
+	}
+}
+
+func TestExtractTextCanceled(t *testing.T) {
+	path := writeFixture(t, "doc.pdf", pdfFixture("BT /F1 12 Tf 72 720 Td (Hello) Tj ET"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if text, err := ExtractText(ctx, path, TypePDF); err == nil {
+		t.Fatalf("ExtractText() = %q, want an error", text)
+	}
+	if _, err := os.Stat(path + ".txt"); !os.IsNotExist(err) {
+		t.Errorf("canceled extraction was cached: %v", err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text string
+		size int
+		want string
+	}{
+		{text: "short", size: 10, want: "short"},
+		{text: "exactly", size: 7, want: "exactly"},
+		{text: "too long", size: 3, want: "too\n[truncated]"},
+		{text: "héllo", size: 2, want: "h\n[truncated]"}, // é is two bytes
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.text, tt.size); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+		}
+	}
+}
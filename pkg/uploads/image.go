@@ -0,0 +1,136 @@
+package uploads
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime"
+
+	// Decoders of the image types accepted as attachments
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxImagePixels bounds the images FitImage decodes, a small file can declare dimensions whose
+// decoded pixels would not fit in memory
+const MaxImagePixels = 40_000_000
+
+// ImageTypes are the image types sent to models as vision content
+var ImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// IsImage reports whether content of the given type is sent to models as an image
+func IsImage(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, imageType := range ImageTypes {
+		if mediaType == imageType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FitImage scales an image down until its longest edge is at most maxEdge pixels and its encoding at
+// most maxBytes, a zero bound is not checked. An image within the bounds is returned as is, a scaled
+// one is encoded as PNG, or as JPEG when it was a JPEG or the PNG is too large. Images of more than
+// MaxImagePixels are rejected before they are decoded.
+func FitImage(data []byte, maxEdge int, maxBytes int) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image: %v", err)
+	}
+	if int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, "", fmt.Errorf("image of %dx%d pixels exceeds the limit of %d pixels", config.Width, config.Height, MaxImagePixels)
+	}
+
+	edge := max(config.Width, config.Height)
+	if (maxEdge <= 0 || edge <= maxEdge) && (maxBytes <= 0 || len(data) <= maxBytes) {
+		return data, "image/" + format, nil
+	}
+
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	if maxEdge > 0 && edge > maxEdge {
+		edge = maxEdge
+	}
+
+	// Shrink further while the encoding is too large
+	for edge > 0 {
+		scaled := scaleImage(source, edge)
+
+		encoded, mediaType, err := encodeImage(scaled, format == "jpeg")
+		if err != nil {
+			return nil, "", err
+		}
+		if maxBytes <= 0 || len(encoded) <= maxBytes {
+			return encoded, mediaType, nil
+		}
+
+		if mediaType == "image/png" {
+			encoded, mediaType, err = encodeImage(scaled, true)
+			if err != nil {
+				return nil, "", err
+			}
+			if len(encoded) <= maxBytes {
+				return encoded, mediaType, nil
+			}
+		}
+
+		edge = edge * 3 / 4
+	}
+
+	return nil, "", fmt.Errorf("failed to fit image in %d bytes", maxBytes)
+}
+
+// scaleImage returns source with its longest edge scaled to edge pixels, keeping its aspect ratio
+func scaleImage(source image.Image, edge int) image.Image {
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if max(width, height) <= edge {
+		return source
+	}
+
+	if width >= height {
+		height = max(height*edge/width, 1)
+		width = edge
+	} else {
+		width = max(width*edge/height, 1)
+		height = edge
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), source, bounds, draw.Over, nil)
+	return scaled
+}
+
+// encodeImage encodes img as JPEG or PNG, a JPEG is drawn over white since it has no transparency
+func encodeImage(img image.Image, asJPEG bool) ([]byte, string, error) {
+	var encoded bytes.Buffer
+	if !asJPEG {
+		if err := png.Encode(&encoded, img); err != nil {
+			return nil, "", fmt.Errorf("failed to encode image: %v", err)
+		}
+		return encoded.Bytes(), "image/png", nil
+	}
+
+	opaque := image.NewRGBA(img.Bounds())
+	draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(opaque, opaque.Bounds(), img, img.Bounds().Min, draw.Over)
+	if err := jpeg.Encode(&encoded, opaque, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %v", err)
+	}
+
+	return encoded.Bytes(), "image/jpeg", nil
+}
@@ -0,0 +1,271 @@
+package uploads
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const (
+	// DefaultDir is where uploads are stored when UPLOADS_DIR is not set
+	DefaultDir = "uploads"
+	// DefaultMaxFileSize bounds a single upload when UPLOADS_MAX_FILE_SIZE is not set
+	DefaultMaxFileSize = 20 << 20 // 20 MiB
+	// DefaultMaxChatSize bounds the uploads of a chat when UPLOADS_MAX_CHAT_SIZE is not set
+	DefaultMaxChatSize = 100 << 20 // 100 MiB
+)
+
+// DefaultAllowedTypes are the content types accepted when UPLOADS_ALLOWED_TYPES is not set
+var DefaultAllowedTypes = []string{
+	"text/*",
+	"application/json",
+	"application/xml",
+	"application/pdf",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// MaxDocumentSize bounds the text of a file placed in the prompt, longer files are truncated
+const MaxDocumentSize = 512 * 1024
+
+// Dir returns the storage directory of uploads
+func Dir() string {
+	if dir := os.Getenv("UPLOADS_DIR"); dir != "" {
+		return dir
+	}
+
+	return DefaultDir
+}
+
+// Limits bounds the uploads of a chat
+type Limits struct {
+	MaxFileSize  int64
+	MaxChatSize  int64
+	AllowedTypes []string // media types, a trailing /* matches a whole family
+}
+
+// LimitsFromEnv reads the upload limits, falling back to the defaults
+func LimitsFromEnv() Limits {
+	limits := Limits{
+		MaxFileSize:  DefaultMaxFileSize,
+		MaxChatSize:  DefaultMaxChatSize,
+		AllowedTypes: DefaultAllowedTypes,
+	}
+
+	if value, err := strconv.ParseInt(os.Getenv("UPLOADS_MAX_FILE_SIZE"), 10, 64); err == nil && value > 0 {
+		limits.MaxFileSize = value
+	}
+	if value, err := strconv.ParseInt(os.Getenv("UPLOADS_MAX_CHAT_SIZE"), 10, 64); err == nil && value > 0 {
+		limits.MaxChatSize = value
+	}
+	if value := os.Getenv("UPLOADS_ALLOWED_TYPES"); value != "" {
+		limits.AllowedTypes = nil
+		for _, allowed := range strings.Split(value, ",") {
+			if allowed = strings.TrimSpace(allowed); allowed != "" {
+				limits.AllowedTypes = append(limits.AllowedTypes, allowed)
+			}
+		}
+	}
+
+	return limits
+}
+
+// Allows reports whether content of the given type may be uploaded
+func (l Limits) Allows(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range l.AllowedTypes {
+		if allowed == "*/*" || allowed == mediaType {
+			return true
+		}
+		if family, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, family+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Save writes the content of the file fileUUID to the storage directory and returns its path and size.
+// The content is written to a temporary file first, so a failed upload leaves nothing behind.
+func Save(fileUUID string, content io.Reader) (string, int64, error) {
+	dir := Dir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", 0, fmt.Errorf("failed to create uploads directory: %v", err)
+	}
+
+	temp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create upload: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	size, err := io.Copy(temp, content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write upload: %v", err)
+	}
+
+	path := filepath.Join(dir, fileUUID)
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return "", 0, fmt.Errorf("failed to store upload: %v", err)
+	}
+
+	return path, size, nil
+}
+
+// Copy stores a copy of the upload at path, with the text extracted from it, as the file fileUUID and
+// returns its path. Copies are hard links where the file system allows them.
+func Copy(path string, fileUUID string) (string, error) {
+	target := filepath.Join(Dir(), fileUUID)
+	if err := copyFile(path, target); err != nil {
+		return "", fmt.Errorf("failed to copy upload: %v", err)
+	}
+
+	// Documents whose text was never extracted have no cache
+	if _, err := os.Stat(path + ".txt"); err == nil {
+		if err := copyFile(path+".txt", target+".txt"); err != nil {
+			os.Remove(target)
+			return "", fmt.Errorf("failed to copy extracted text: %v", err)
+		}
+	}
+
+	return target, nil
+}
+
+func copyFile(source string, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+	}
+
+	return err
+}
+
+// Remove deletes a stored upload and the text extracted from it, missing files are not an error
+func Remove(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	for _, name := range []string{path, path + ".txt"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove upload: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SniffSize is how much of the content DetectType needs
+const SniffSize = 3072
+
+// DetectType returns the content type of a file sniffed from head, its first SniffSize bytes. The type
+// sent by the client is not trusted, the extension only refines plain text, such as text/markdown.
+func DetectType(name string, head []byte) string {
+	sniffed := mimetype.Detect(head)
+	if sniffed.Is("text/plain") {
+		if byExtension := mime.TypeByExtension(filepath.Ext(name)); IsText(byExtension) {
+			return byExtension
+		}
+	}
+
+	return sniffed.String()
+}
+
+// IsText reports whether content of the given type is read as text
+func IsText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml",
+		"application/yaml", "application/toml", "application/x-sh", "application/sql":
+		return true
+	}
+
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// ReadText returns the content of a stored text upload, truncated to MaxDocumentSize.
+// Content that is not valid UTF-8 is reported as an error.
+func ReadText(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open upload: %v", err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, MaxDocumentSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read upload: %v", err)
+	}
+
+	truncated := len(content) > MaxDocumentSize
+	if truncated {
+		content = content[:MaxDocumentSize]
+		// Do not cut a multi-byte character in half
+		for i := 0; i < utf8.UTFMax-1 && len(content) > 0; i++ {
+			if r, size := utf8.DecodeLastRune(content); r != utf8.RuneError || size > 1 {
+				break
+			}
+			content = content[:len(content)-1]
+		}
+	}
+
+	if !utf8.Valid(content) {
+		return "", fmt.Errorf("upload is not valid UTF-8 text")
+	}
+
+	text := string(content)
+	if truncated {
+		text += "\n[truncated]"
+	}
+
+	return text, nil
+}
+
+// Document delimits the text of a file so the model can tell it apart from the message
+func Document(name string, contentType string, text string) string {
+	return fmt.Sprintf("<document name=%q type=%q>\n%s\n</document>\n\n", name, contentType, text)
+}
@@ -3,11 +3,14 @@ package anthropic
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"wisdomizer/pkg/llm"
 )
 
 const (
@@ -15,6 +18,7 @@ const (
 	AnthropicHeaderAPIKey     = "x-api-key"
 	AnthropicHeaderVersion    = "anthropic-version"
 	AnthropicVersionSonnet3_7 = "2023-06-01"
+	DefaultModel              = "claude-3-7-sonnet-20250219"
 )
 
 // Tool represents a function that can be called during the model's generation process
@@ -33,33 +37,63 @@ type JSONSchema struct {
 
 // Property represents a property in a JSON schema
 type Property struct {
-	Type        string `json:"type"`
-	Description string `json:"description,omitempty"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
 }
 
-// ToolMessage represents a message regarding tool usage in the conversation
-type ToolMessage struct {
-	Name    string          `json:"name"`
-	Input   json.RawMessage `json:"input"`
-	Output  json.RawMessage `json:"output,omitempty"`
-	ToolID  string          `json:"tool_id,omitempty"`
-	Content []ToolContent   `json:"content,omitempty"`
+// Content block types of the Messages API
+const (
+	BlockText       = "text"
+	BlockToolUse    = "tool_use"
+	BlockToolResult = "tool_result"
+	BlockImage      = "image"
+	BlockDocument   = "document"
+)
+
+// ContentBlock represents a typed block of message content, used in both requests and responses
+type ContentBlock struct {
+	Type string `json:"type"`
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string         `json:"tool_use_id,omitempty"`
+	Content   []ContentBlock `json:"content,omitempty"`
+	IsError   bool           `json:"is_error,omitempty"`
+
+	// image, document
+	Source *Source `json:"source,omitempty"`
+
+	// document
+	Title string `json:"title,omitempty"`
 }
 
-// ToolContent represents content within a tool message
-type ToolContent struct {
-	Type  string `json:"type"`
-	Text  string `json:"text,omitempty"`
-	Image string `json:"image,omitempty"`
+// Source represents the inline data of an image or document block
+type Source struct {
+	Type      string `json:"type"` // base64
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
 }
 
 // Message represents a message in the conversation
 type Message struct {
-	Role    string        `json:"role"`
-	Content string        `json:"content,omitempty"`
-	ToolUse *ToolMessage  `json:"tool_use,omitempty"`
-	Tool    *ToolMessage  `json:"tool,omitempty"`
-	Tools   []ToolMessage `json:"tools,omitempty"`
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
+}
+
+// TextMessage creates a message with a single text block
+func TextMessage(role string, text string) Message {
+	return Message{
+		Role:    role,
+		Content: []ContentBlock{{Type: BlockText, Text: text}},
+	}
 }
 
 // ToolChoice represents the model's tool selection behavior
@@ -74,28 +108,22 @@ type ChatRequest struct {
 	Messages    []Message   `json:"messages"`
 	System      string      `json:"system,omitempty"`
 	MaxTokens   int         `json:"max_tokens,omitempty"`
-	Temperature float64     `json:"temperature,omitempty"`
+	Temperature *float64    `json:"temperature,omitempty"` // nil keeps the API default, 0 is a valid value
 	Stream      bool        `json:"stream,omitempty"`
 	Tools       []Tool      `json:"tools,omitempty"`
 	ToolChoice  *ToolChoice `json:"tool_choice,omitempty"`
 }
 
 type Option struct {
-	Callback    func(chunk string) `json:"callback,omitempty"` // only for stream
-	Messages    []Message          `json:"messages"`
-	MaxTokens   int                `json:"max_tokens,omitempty"`
-	Temperature float64            `json:"temperature,omitempty"`
-	System      string             `json:"system,omitempty"`
-	Tools       []Tool             `json:"tools,omitempty"`
-	ToolChoice  *ToolChoice        `json:"tool_choice,omitempty"`
-	Stream      bool               `json:"stream,omitempty"`
-}
-
-// ContentBlock represents a block of content in the API response
-type ContentBlock struct {
-	Type  string `json:"type"`
-	Text  string `json:"text,omitempty"`
-	Index int    `json:"index,omitempty"`
+	Callback    func(delta llm.Delta) `json:"-"` // only for stream
+	Model       string                `json:"model,omitempty"`
+	Messages    []Message             `json:"messages"`
+	MaxTokens   int                   `json:"max_tokens,omitempty"`
+	Temperature *float64              `json:"temperature,omitempty"` // nil keeps the API default
+	System      string                `json:"system,omitempty"`
+	Tools       []Tool                `json:"tools,omitempty"`
+	ToolChoice  *ToolChoice           `json:"tool_choice,omitempty"`
+	Stream      bool                  `json:"stream,omitempty"`
 }
 
 // ChatResponse represents the response from the Sonnet 3.7 API
@@ -107,22 +135,32 @@ type ChatResponse struct {
 	Model        string         `json:"model"`
 	StopReason   string         `json:"stop_reason"`
 	StopSequence string         `json:"stop_sequence"`
-	Usage        struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage"`
-	Error *struct {
-		Type    string `json:"type"`
-		Message string `json:"message"`
-	} `json:"error,omitempty"`
+	Usage        Usage          `json:"usage"`
+	Error        *APIError      `json:"error,omitempty"`
+}
+
+// Usage represents the token accounting of a response
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// APIError represents an error returned by the API
+type APIError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
 }
 
-// Chat makes a request to the Anthropic Sonnet 3.7 API
-func Chat(option Option) (*ChatResponse, error) {
+// Chat makes a request to the Anthropic Sonnet 3.7 API, cancelling ctx aborts it
+func Chat(ctx context.Context, option Option) (*ChatResponse, error) {
 	apiKey := os.Getenv("ANTHROPIC_API_KEY")
 
+	if option.Model == "" {
+		option.Model = DefaultModel
+	}
+
 	req := ChatRequest{
-		Model:       "claude-3-7-sonnet-20250219",
+		Model:       option.Model,
 		Messages:    option.Messages,
 		System:      option.System,
 		MaxTokens:   option.MaxTokens,
@@ -132,15 +170,10 @@ func Chat(option Option) (*ChatResponse, error) {
 		Stream:      option.Stream,
 	}
 
-	// Force processComplete mode when tools are provided
-	if len(option.Tools) > 0 {
-		req.Stream = false
-
-		// If no specific tool choice is provided but tools are, default to "auto"
-		if option.ToolChoice == nil {
-			req.ToolChoice = &ToolChoice{
-				Type: "auto",
-			}
+	// If no specific tool choice is provided but tools are, default to "auto"
+	if len(option.Tools) > 0 && option.ToolChoice == nil {
+		req.ToolChoice = &ToolChoice{
+			Type: "auto",
 		}
 	}
 
@@ -149,7 +182,7 @@ func Chat(option Option) (*ChatResponse, error) {
 		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", AnthropicAPIURL, bytes.NewBuffer(reqBody))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", AnthropicAPIURL, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
@@ -181,29 +214,22 @@ func Chat(option Option) (*ChatResponse, error) {
 
 // Delta represents a streaming delta update
 type Delta struct {
-	Type  string `json:"type"`
-	Text  string `json:"text,omitempty"`
-	Index int    `json:"index,omitempty"`
+	Type         string `json:"type"` // text_delta, input_json_delta
+	Text         string `json:"text,omitempty"`
+	PartialJSON  string `json:"partial_json,omitempty"`
+	StopReason   string `json:"stop_reason,omitempty"`
+	StopSequence string `json:"stop_sequence,omitempty"`
 }
 
 // StreamEvent represents an event in the stream response
 type StreamEvent struct {
-	Type    string `json:"type"`
-	Message *struct {
-		ID      string  `json:"id"`
-		Role    string  `json:"role"`
-		Content []Delta `json:"content"`
-	} `json:"message,omitempty"`
-	ID           string  `json:"id,omitempty"`
-	Role         string  `json:"role,omitempty"`
-	Content      []Delta `json:"content,omitempty"`
-	ContentBlock Delta   `json:"delta,omitempty"`
-	StopReason   string  `json:"stop_reason,omitempty"`
-	StopSequence string  `json:"stop_sequence,omitempty"`
-	Usage        *struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage,omitempty"`
+	Type         string        `json:"type"`
+	Index        int           `json:"index"`
+	Message      *ChatResponse `json:"message,omitempty"`
+	ContentBlock *ContentBlock `json:"content_block,omitempty"`
+	Delta        *Delta        `json:"delta,omitempty"`
+	Usage        *Usage        `json:"usage,omitempty"`
+	Error        *APIError     `json:"error,omitempty"`
 }
 
 func processStream(resp *http.Response, option Option) (*ChatResponse, error) {
@@ -212,6 +238,9 @@ func processStream(resp *http.Response, option Option) (*ChatResponse, error) {
 	fullResponse.Content = []ContentBlock{}
 	fullResponse.Role = "assistant"
 
+	// tool_use input arrives as partial JSON, keyed by block index
+	inputs := map[int]*strings.Builder{}
+
 	for {
 		line, err := reader.ReadBytes('\n')
 		if err == io.EOF {
@@ -247,39 +276,70 @@ func processStream(resp *http.Response, option Option) (*ChatResponse, error) {
 		// Process event based on type
 		switch event.Type {
 		case "message_start":
-			fullResponse.ID = event.ID
-			fullResponse.Model = "claude-3-7-sonnet-20250219" // Using the model from request
+			fullResponse.Model = option.Model // Using the model from request
+			if event.Message != nil {
+				fullResponse.ID = event.Message.ID
+				fullResponse.Usage.InputTokens = event.Message.Usage.InputTokens
+			}
 
 		case "content_block_start":
 			// Initialize a new content block
-			block := ContentBlock{
-				Type:  event.ContentBlock.Type,
-				Index: event.ContentBlock.Index,
+			if event.ContentBlock == nil || event.Index != len(fullResponse.Content) {
+				return nil, fmt.Errorf("unexpected content block start at index %d", event.Index)
+			}
+			block := *event.ContentBlock
+			if block.Type == BlockToolUse {
+				block.Input = nil
+				inputs[event.Index] = &strings.Builder{}
 			}
 			fullResponse.Content = append(fullResponse.Content, block)
 
 		case "content_block_delta":
-			// Update the last content block with delta
-			if len(fullResponse.Content) > 0 {
-				lastIdx := len(fullResponse.Content) - 1
-				fullResponse.Content[lastIdx].Text += event.ContentBlock.Text
+			if event.Delta == nil || event.Index >= len(fullResponse.Content) {
+				continue
+			}
+
+			switch event.Delta.Type {
+			case "text_delta":
+				fullResponse.Content[event.Index].Text += event.Delta.Text
 
 				// If callback is provided, call it with the new text
 				if option.Callback != nil {
-					option.Callback(event.ContentBlock.Text)
+					option.Callback(llm.Delta{Type: llm.DeltaText, Text: event.Delta.Text})
+				}
+			case "input_json_delta":
+				if input, ok := inputs[event.Index]; ok {
+					input.WriteString(event.Delta.PartialJSON)
 				}
 			}
 
+		case "content_block_stop":
+			if input, ok := inputs[event.Index]; ok {
+				raw := input.String()
+				if raw == "" {
+					raw = "{}"
+				}
+				fullResponse.Content[event.Index].Input = json.RawMessage(raw)
+			}
+
 		case "message_delta":
 			// Handle any updates to the message itself
-
-		case "message_stop":
-			fullResponse.StopReason = event.StopReason
-			fullResponse.StopSequence = event.StopSequence
+			if event.Delta != nil {
+				fullResponse.StopReason = event.Delta.StopReason
+				fullResponse.StopSequence = event.Delta.StopSequence
+			}
 			if event.Usage != nil {
-				fullResponse.Usage.InputTokens = event.Usage.InputTokens
 				fullResponse.Usage.OutputTokens = event.Usage.OutputTokens
 			}
+
+		case "error":
+			if event.Error != nil {
+				return nil, fmt.Errorf("API error: %s - %s", event.Error.Type, event.Error.Message)
+			}
+			return nil, fmt.Errorf("API error in stream")
+
+		case "message_stop":
+			// The stream is complete
 		}
 	}
 
@@ -301,42 +361,5 @@ func processComplete(resp *http.Response, option Option) (*ChatResponse, error)
 		return nil, fmt.Errorf("API error: %s - %s", apiResp.Error.Type, apiResp.Error.Message)
 	}
 
-	// Handle tool use if present in the response
-	if len(apiResp.Content) > 0 {
-		for _, block := range apiResp.Content {
-			// Process tool calls if found in the content
-			if block.Type == "tool_use" {
-				var toolMessage ToolMessage
-				if err := json.Unmarshal([]byte(block.Text), &toolMessage); err != nil {
-					return nil, fmt.Errorf("failed to unmarshal tool message: %w", err)
-				}
-
-				// Process the tool call
-				output, err := ProcessTool(toolMessage)
-				if err != nil {
-					return nil, fmt.Errorf("failed to process tool: %w", err)
-				}
-
-				// Create a new message with the tool output
-				toolResponse := Message{
-					Role: "tool",
-					Tool: &ToolMessage{
-						Name:   toolMessage.Name,
-						Input:  toolMessage.Input,
-						Output: output,
-					},
-				}
-
-				// Add the tool response to the messages and make a new API call
-				newMessages := append(option.Messages, toolResponse)
-				newOption := option
-				newOption.Messages = newMessages
-
-				// Recursively call Chat with the updated messages
-				return Chat(newOption)
-			}
-		}
-	}
-
 	return &apiResp, nil
 }
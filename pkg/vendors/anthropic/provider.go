@@ -0,0 +1,171 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+	"wisdomizer/pkg/llm"
+)
+
+// Provider exposes the Anthropic Messages API as an llm.Provider
+type Provider struct{}
+
+func (Provider) Name() string {
+	return "anthropic"
+}
+
+func (Provider) DefaultModel() string {
+	return DefaultModel
+}
+
+// ImageLimits keeps images within the size the API accepts, and at the edge beyond which it scales them
+// down itself at the cost of latency
+func (Provider) ImageLimits() llm.ImageLimits {
+	return llm.ImageLimits{MaxEdge: 1568, MaxBytes: 5 << 20}
+}
+
+// DocumentLimits keeps PDFs within the page limit and, once base64 encoded, the request size limit of
+// the API. PDFs are read natively when ANTHROPIC_NATIVE_PDF is true, otherwise their text is sent.
+func (Provider) DocumentLimits() (llm.DocumentLimits, bool) {
+	return llm.DocumentLimits{MaxPages: 100, MaxBytes: 24 << 20}, os.Getenv("ANTHROPIC_NATIVE_PDF") == "true"
+}
+
+// MaxTemperature is the top of the 0 to 1 range the API accepts
+func (Provider) MaxTemperature() float64 {
+	return 1
+}
+
+func (Provider) Chat(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	temperature := req.TemperatureOrDefault()
+	option := Option{
+		Model:       req.Model,
+		System:      req.System,
+		MaxTokens:   req.MaxTokens,
+		Temperature: &temperature,
+		Stream:      req.Stream,
+		Callback:    req.Callback,
+	}
+
+	for _, msg := range req.Messages {
+		option.Messages = append(option.Messages, toMessage(msg))
+	}
+
+	for _, tool := range req.Tools {
+		option.Tools = append(option.Tools, toTool(tool))
+	}
+
+	resp, err := Chat(ctx, option)
+	if err != nil {
+		return nil, err
+	}
+
+	return toResponse(resp), nil
+}
+
+// toMessage converts a provider-agnostic message into content blocks
+func toMessage(msg llm.Message) Message {
+	message := Message{Role: msg.Role}
+
+	for _, block := range msg.Content {
+		switch block.Type {
+		case llm.BlockText:
+			message.Content = append(message.Content, ContentBlock{
+				Type: BlockText,
+				Text: block.Text,
+			})
+		case llm.BlockToolUse:
+			message.Content = append(message.Content, ContentBlock{
+				Type:  BlockToolUse,
+				ID:    block.ToolCallID,
+				Name:  block.Name,
+				Input: block.Input,
+			})
+		case llm.BlockToolResult:
+			message.Content = append(message.Content, ContentBlock{
+				Type:      BlockToolResult,
+				ToolUseID: block.ToolCallID,
+				Content:   []ContentBlock{{Type: BlockText, Text: block.Text}},
+				IsError:   block.IsError,
+			})
+		case llm.BlockImage:
+			message.Content = append(message.Content, ContentBlock{
+				Type: BlockImage,
+				Source: &Source{
+					Type:      "base64",
+					MediaType: block.MediaType,
+					Data:      base64.StdEncoding.EncodeToString(block.Data),
+				},
+			})
+		case llm.BlockDocument:
+			message.Content = append(message.Content, ContentBlock{
+				Type: BlockDocument,
+				Source: &Source{
+					Type:      "base64",
+					MediaType: block.MediaType,
+					Data:      base64.StdEncoding.EncodeToString(block.Data),
+				},
+				Title: block.Name,
+			})
+		}
+	}
+
+	return message
+}
+
+// toTool converts a provider-agnostic tool definition
+func toTool(tool llm.Tool) Tool {
+	properties := make(map[string]Property, len(tool.InputSchema.Properties))
+	for name, prop := range tool.InputSchema.Properties {
+		properties[name] = Property{
+			Type:        prop.Type,
+			Description: prop.Description,
+			Enum:        prop.Enum,
+		}
+	}
+
+	return Tool{
+		Name:        tool.Name,
+		Description: tool.Description,
+		InputSchema: JSONSchema{
+			Type:       tool.InputSchema.Type,
+			Properties: properties,
+			Required:   tool.InputSchema.Required,
+		},
+	}
+}
+
+// toResponse converts the content blocks of an API response
+func toResponse(resp *ChatResponse) *llm.Response {
+	var text strings.Builder
+	var blocks []llm.ContentBlock
+	for _, block := range resp.Content {
+		switch block.Type {
+		case BlockText:
+			text.WriteString(block.Text)
+			blocks = append(blocks, llm.ContentBlock{
+				Type: llm.BlockText,
+				Text: block.Text,
+			})
+		case BlockToolUse:
+			blocks = append(blocks, llm.ContentBlock{
+				Type:       llm.BlockToolUse,
+				ToolCallID: block.ID,
+				Name:       block.Name,
+				Input:      block.Input,
+			})
+		}
+	}
+
+	return &llm.Response{
+		ID:         resp.ID,
+		Model:      resp.Model,
+		Content:    text.String(),
+		Blocks:     blocks,
+		StopReason: resp.StopReason,
+		Usage: llm.Usage{
+			InputTokens:  resp.Usage.InputTokens,
+			OutputTokens: resp.Usage.OutputTokens,
+		},
+	}
+}
@@ -3,6 +3,7 @@ package openai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,11 @@ import (
 	"time"
 )
 
+const (
+	OpenAIAPIURL = "https://api.openai.com/v1/chat/completions"
+	DefaultModel = "gpt-4o-mini"
+)
+
 // tools request
 type Tool struct {
 	Type     string                 `json:"type"`
@@ -40,68 +46,100 @@ type Property struct {
 // tools request
 
 type Option struct {
-	Message     string             `json:"message"`
+	Model       string             `json:"model,omitempty"`
 	System      string             `json:"system"`
-	History     []Message          `json:"history"`
+	Messages    []Message          `json:"messages"`
+	MaxTokens   int                `json:"max_tokens,omitempty"`
 	Tools       []Tool             `json:"tools,omitempty"`
 	Stream      bool               `json:"stream,omitempty"`
-	Callback    func(chunk string) `json:"callback,omitempty"` // only for stream
-	Temperature float64            `json:"temperature,omitempty"`
+	Callback    func(chunk string) `json:"callback,omitempty"`    // only for stream
+	Temperature *float64           `json:"temperature,omitempty"` // nil keeps the API default, 0 is a valid value
 	OutputType  string             `json:"output_type,omitempty"`
 	Penalty     float64            `json:"penalty,omitempty"`
 }
 
 // Request and Response model
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string        `json:"role"`
+	Content    string        `json:"content"`
+	Parts      []ContentPart `json:"-"` // multimodal content, sent as the content instead of the text when set
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID string        `json:"tool_call_id,omitempty"`
+}
+
+// MarshalJSON sends Parts as the content array when the message has any
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	if len(m.Parts) == 0 {
+		return json.Marshal(message(m))
+	}
+
+	return json.Marshal(struct {
+		message
+		Content []ContentPart `json:"content"`
+	}{message(m), m.Parts})
+}
+
+// Content part types
+const (
+	PartText     = "text"
+	PartImageURL = "image_url"
+)
+
+// ContentPart represents a typed part of multimodal message content
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL references an image by URL or inline as a data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
 }
 
 type ChatRequest struct {
-	Model            string         `json:"model"`
-	Messages         []Message      `json:"messages"`
-	Stream           bool           `json:"stream"`
-	Tools            []Tool         `json:"tools,omitempty"`
-	Temperature      float64        `json:"temperature,omitempty"`
-	ResponseFormat   ResponseFormat `json:"response_format,omitempty"`
-	Seed             int64          `json:"seed,omitempty"`
-	FrequencyPenalty float64        `json:"frequency_penalty,omitempty"`
+	Model            string          `json:"model"`
+	Messages         []Message       `json:"messages"`
+	Stream           bool            `json:"stream"`
+	StreamOptions    *StreamOptions  `json:"stream_options,omitempty"`
+	MaxTokens        int             `json:"max_tokens,omitempty"`
+	Tools            []Tool          `json:"tools,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"` // nil keeps the API default, 0 is a valid value
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+	Seed             int64           `json:"seed,omitempty"`
+	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
 }
 
 type ResponseFormat struct {
 	Type string `json:"type,omitempty"`
 }
 
-type ChatResponse struct {
-	Choices []struct {
-		Message Message `json:"message,omitempty"`
-		Delta   Delta   `json:"delta,omitempty"`
-	} `json:"choices"`
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
-type ToolResponse struct {
-	Choices []struct {
-		Message ToolMessage `json:"message,omitempty"`
-	} `json:"choices"`
+type Choice struct {
+	Message      Message `json:"message,omitempty"`
+	Delta        Delta   `json:"delta,omitempty"`
+	FinishReason string  `json:"finish_reason,omitempty"`
 }
 
-func (tr *ToolResponse) JSON() string {
-	type output struct {
-		Name string `json:"name"`
-		Args string `json:"args"`
-	}
-
-	o := output{
-		Name: tr.Choices[0].Message.ToolCalls[0].Function.Name,
-		Args: tr.Choices[0].Message.ToolCalls[0].Function.Arguments,
-	}
-
-	s, _ := json.Marshal(o)
-	return string(s)
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
-type ToolMessage struct {
-	ToolCalls []ToolCall `json:"tool_calls"`
+type ChatResponse struct {
+	ID      string   `json:"id"`
+	Model   string   `json:"model"`
+	Choices []Choice `json:"choices"`
+	Usage   *Usage   `json:"usage,omitempty"`
+	Error   *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
 }
 
 type ToolCall struct {
@@ -121,10 +159,13 @@ type Delta struct {
 
 // Request and Response model
 
-func Chat(option Option) (*Message, error) {
-	url := "https://api.openai.com/v1/chat/completions"
+func Chat(ctx context.Context, option Option) (*ChatResponse, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
 
+	if option.Model == "" {
+		option.Model = DefaultModel
+	}
+
 	messages := []Message{
 		{
 			Role:    "system",
@@ -132,19 +173,8 @@ func Chat(option Option) (*Message, error) {
 		},
 	}
 
-	if len(option.History) > 0 {
-		messages = append(messages, option.History...)
-	}
-
-	messages = append(messages, Message{
-		Role:    "user",
-		Content: option.Message,
-	})
-
-	// default temperature is 0.7 and the lowest is 0.1
-	temperature := 0.7
-	if option.Temperature > 0 {
-		temperature = option.Temperature
+	if len(option.Messages) > 0 {
+		messages = append(messages, option.Messages...)
 	}
 
 	penalty := 0.0
@@ -155,24 +185,37 @@ func Chat(option Option) (*Message, error) {
 	seed := time.Now().Unix()
 
 	requestBody := ChatRequest{
-		Model:            "gpt-4o-mini",
+		Model:            option.Model,
 		Messages:         messages,
 		Stream:           option.Stream,
+		MaxTokens:        option.MaxTokens,
 		Tools:            option.Tools,
-		Temperature:      temperature,
-		ResponseFormat:   ResponseFormat{Type: option.OutputType},
+		Temperature:      option.Temperature,
 		Seed:             seed,
 		FrequencyPenalty: penalty,
 	}
 
+	if option.OutputType != "" {
+		requestBody.ResponseFormat = &ResponseFormat{Type: option.OutputType}
+	}
+
+	// Tool call arguments are only read from complete responses
+	if len(option.Tools) > 0 {
+		requestBody.Stream = false
+	}
+
+	if requestBody.Stream {
+		requestBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, OpenAIAPIURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -181,28 +224,39 @@ func Chat(option Option) (*Message, error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read error response body: %w", err)
+		}
+		return nil, fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(body))
+	}
 
-	var content string
+	if requestBody.Stream {
+		return processStream(resp, option)
+	}
 
-	if !option.Stream {
-		content, _ = processComplete(resp, option)
-	} else {
+	chatResponse, err := processComplete(resp)
+	if err != nil {
+		return nil, err
+	}
 
-		content, _ = processStream(resp, option)
+	// Deliver the text in one chunk when streaming was requested but not possible
+	if option.Stream && option.Callback != nil && chatResponse.Choices[0].Message.Content != "" {
+		option.Callback(chatResponse.Choices[0].Message.Content)
 	}
 
-	return &Message{
-		Role:    "assistant",
-		Content: content,
-	}, nil
+	return chatResponse, nil
 }
 
-func processStream(resp *http.Response, option Option) (string, error) {
+func processStream(resp *http.Response, option Option) (*ChatResponse, error) {
 	reader := bufio.NewReader(resp.Body)
-	content := ""
+	fullResponse := &ChatResponse{
+		Choices: []Choice{{Message: Message{Role: "assistant"}}},
+	}
 
 	for {
 		line, err := reader.ReadString('\n')
@@ -210,54 +264,57 @@ func processStream(resp *http.Response, option Option) (string, error) {
 			break
 		}
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("error reading stream: %w", err)
 		}
 
 		if len(line) > 6 {
-			var chatResponse ChatResponse
+			var chunk ChatResponse
 			line = line[6:]
-			err = json.Unmarshal([]byte(line), &chatResponse)
+			err = json.Unmarshal([]byte(line), &chunk)
 			if err != nil {
 				continue
 			}
 
-			if len(chatResponse.Choices) > 0 {
-				chunk := chatResponse.Choices[0].Delta.Content
-				content += chunk
-				if option.Callback != nil {
-					option.Callback(chunk)
+			fullResponse.ID = chunk.ID
+			fullResponse.Model = chunk.Model
+			if chunk.Usage != nil {
+				fullResponse.Usage = chunk.Usage
+			}
+
+			if len(chunk.Choices) > 0 {
+				text := chunk.Choices[0].Delta.Content
+				fullResponse.Choices[0].Message.Content += text
+				if chunk.Choices[0].FinishReason != "" {
+					fullResponse.Choices[0].FinishReason = chunk.Choices[0].FinishReason
+				}
+				if option.Callback != nil && text != "" {
+					option.Callback(text)
 				}
 			}
 		}
 	}
-	return content, nil
+
+	return fullResponse, nil
 }
 
-func processComplete(resp *http.Response, option Option) (string, error) {
-	content := ""
+func processComplete(resp *http.Response) (*ChatResponse, error) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	var chatResponse ChatResponse
-	var toolResponse ToolResponse
+	if err := json.Unmarshal(body, &chatResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
 
-	// handle either tool or chat based on tools provided or not
-	if len(option.Tools) > 0 {
-		err = json.Unmarshal(body, &toolResponse)
-		if err != nil {
-			return "", err
-		}
-		content = toolResponse.JSON()
-	} else {
-		err = json.Unmarshal(body, &chatResponse)
-		if err != nil {
-			return "", err
-		}
-		fmt.Println(string(body))
-		content = chatResponse.Choices[0].Message.Content
+	if chatResponse.Error != nil {
+		return nil, fmt.Errorf("API error: %s - %s", chatResponse.Error.Type, chatResponse.Error.Message)
+	}
+
+	if len(chatResponse.Choices) == 0 {
+		return nil, fmt.Errorf("API response has no choices")
 	}
 
-	return content, nil
+	return &chatResponse, nil
 }
@@ -0,0 +1,194 @@
+package openai
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"wisdomizer/pkg/llm"
+)
+
+// Provider exposes the OpenAI Chat Completions API as an llm.Provider
+type Provider struct{}
+
+func (Provider) Name() string {
+	return "openai"
+}
+
+func (Provider) DefaultModel() string {
+	return DefaultModel
+}
+
+// ImageLimits keeps images within the size the API accepts, and at the edge it scales high detail images to
+func (Provider) ImageLimits() llm.ImageLimits {
+	return llm.ImageLimits{MaxEdge: 2048, MaxBytes: 20 << 20}
+}
+
+func (Provider) Chat(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	temperature := req.TemperatureOrDefault()
+	option := Option{
+		Model:       req.Model,
+		System:      req.System,
+		MaxTokens:   req.MaxTokens,
+		Temperature: &temperature,
+		Stream:      req.Stream,
+	}
+
+	for _, msg := range req.Messages {
+		option.Messages = append(option.Messages, toMessages(msg)...)
+	}
+
+	for _, tool := range req.Tools {
+		option.Tools = append(option.Tools, toTool(tool))
+	}
+
+	if req.Callback != nil {
+		option.Callback = func(chunk string) {
+			req.Callback(llm.Delta{Type: llm.DeltaText, Text: chunk})
+		}
+	}
+
+	resp, err := Chat(ctx, option)
+	if err != nil {
+		return nil, err
+	}
+
+	return toResponse(resp), nil
+}
+
+// toMessages converts a provider-agnostic message, tool results become separate tool messages
+func toMessages(msg llm.Message) []Message {
+	message := Message{
+		Role:    msg.Role,
+		Content: msg.Text(),
+	}
+
+	// Images can only be sent within content parts
+	for _, block := range msg.Content {
+		if block.Type == llm.BlockImage {
+			message.Parts = toParts(msg)
+			break
+		}
+	}
+
+	var results []Message
+	for _, block := range msg.Content {
+		switch block.Type {
+		case llm.BlockToolUse:
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				ID:   block.ToolCallID,
+				Type: "function",
+				Function: Function{
+					Name:      block.Name,
+					Arguments: string(block.Input),
+				},
+			})
+		case llm.BlockToolResult:
+			results = append(results, Message{
+				Role:       "tool",
+				Content:    block.Text,
+				ToolCallID: block.ToolCallID,
+			})
+		}
+	}
+
+	if len(results) == 0 {
+		return []Message{message}
+	}
+
+	// Tool messages must directly follow the assistant tool calls
+	if message.Content == "" {
+		return results
+	}
+
+	return append(results, message)
+}
+
+// toParts converts the text and image blocks of a message into content parts, images are sent as
+// data URLs
+func toParts(msg llm.Message) []ContentPart {
+	var parts []ContentPart
+	for _, block := range msg.Content {
+		switch block.Type {
+		case llm.BlockText:
+			parts = append(parts, ContentPart{Type: PartText, Text: block.Text})
+		case llm.BlockImage:
+			parts = append(parts, ContentPart{
+				Type: PartImageURL,
+				ImageURL: &ImageURL{
+					URL: "data:" + block.MediaType + ";base64," + base64.StdEncoding.EncodeToString(block.Data),
+				},
+			})
+		}
+	}
+
+	return parts
+}
+
+// toTool converts a provider-agnostic tool definition into a function tool
+func toTool(tool llm.Tool) Tool {
+	properties := make(map[string]Property, len(tool.InputSchema.Properties))
+	for name, prop := range tool.InputSchema.Properties {
+		properties[name] = Property{
+			Type:        prop.Type,
+			Description: prop.Description,
+			Enum:        prop.Enum,
+		}
+	}
+
+	return Tool{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters: ToolParams{
+				Type:       tool.InputSchema.Type,
+				Properties: properties,
+				Required:   tool.InputSchema.Required,
+			},
+		},
+	}
+}
+
+// toResponse converts the first choice of an API response
+func toResponse(resp *ChatResponse) *llm.Response {
+	response := &llm.Response{
+		ID:    resp.ID,
+		Model: resp.Model,
+	}
+
+	if len(resp.Choices) > 0 {
+		message := resp.Choices[0].Message
+		response.Content = message.Content
+		response.StopReason = resp.Choices[0].FinishReason
+
+		if message.Content != "" {
+			response.Blocks = append(response.Blocks, llm.ContentBlock{
+				Type: llm.BlockText,
+				Text: message.Content,
+			})
+		}
+
+		for _, call := range message.ToolCalls {
+			input := json.RawMessage(call.Function.Arguments)
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+
+			response.Blocks = append(response.Blocks, llm.ContentBlock{
+				Type:       llm.BlockToolUse,
+				ToolCallID: call.ID,
+				Name:       call.Function.Name,
+				Input:      input,
+			})
+		}
+	}
+
+	if resp.Usage != nil {
+		response.Usage = llm.Usage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		}
+	}
+
+	return response
+}
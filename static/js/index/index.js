@@ -205,7 +205,8 @@ $(document).ready(function() {
   let currentStreamController = null;
   let currentChatUUID = null;
   let currentTopicLoadingId = null;
-  let systemPrompt = "You are a helpful AI assistant. Format your responses using Markdown for better readability. Use code blocks with language specification for code examples.";
+  const promptedToolCalls = new Set(); // tool calls the user was already asked to approve
+  let systemPrompt = ""; // prompt of the current topic, from GET /chat/:uuid
   
   // Configure Marked.js for Markdown rendering
   configureMarkdown();
@@ -388,18 +389,7 @@ $(document).ready(function() {
   });
   
   saveSystemPromptBtn.on('click', function() {
-    const newPrompt = systemPromptInput.val().trim();
-    if (newPrompt) {
-      systemPrompt = newPrompt;
-      systemPromptDisplay.text(systemPrompt);
-      mobileSystemPromptDisplay.text(systemPrompt);
-      systemPromptForm.addClass('hidden');
-      systemPromptDisplay.removeClass('hidden');
-      localStorage.setItem('systemPrompt', systemPrompt);
-      createNotification('System prompt updated', 'success');
-    } else {
-      createNotification('System prompt cannot be empty', 'error');
-    }
+    saveSystemPrompt(systemPromptInput.val().trim(), systemPromptForm, systemPromptDisplay);
   });
   
   // System prompt handling - mobile
@@ -416,28 +406,58 @@ $(document).ready(function() {
   });
   
   mobileSaveSystemPromptBtn.on('click', function() {
-    const newPrompt = mobileSystemPromptInput.val().trim();
-    if (newPrompt) {
-      systemPrompt = newPrompt;
-      systemPromptDisplay.text(systemPrompt);
-      mobileSystemPromptDisplay.text(systemPrompt);
-      mobileSystemPromptForm.addClass('hidden');
-      mobileSystemPromptDisplay.removeClass('hidden');
-      localStorage.setItem('systemPrompt', systemPrompt);
-      createNotification('System prompt updated', 'success');
-    } else {
-      createNotification('System prompt cannot be empty', 'error');
-    }
+    saveSystemPrompt(mobileSystemPromptInput.val().trim(), mobileSystemPromptForm, mobileSystemPromptDisplay);
   });
   
-  // Load saved system prompt if available
-  const savedSystemPrompt = localStorage.getItem('systemPrompt');
-  if (savedSystemPrompt) {
-    systemPrompt = savedSystemPrompt;
+  // The system prompt belongs to the topic, drop the global one earlier versions kept
+  localStorage.removeItem('systemPrompt');
+  
+  // Show the system prompt of the current topic
+  function showSystemPrompt(prompt) {
+    systemPrompt = prompt || '';
     systemPromptDisplay.text(systemPrompt);
     mobileSystemPromptDisplay.text(systemPrompt);
   }
   
+  // Store the system prompt on the current topic as its next version
+  function saveSystemPrompt(newPrompt, form, display) {
+    if (!newPrompt) {
+      createNotification('System prompt cannot be empty', 'error');
+      return;
+    }
+    if (!currentChatUUID) {
+      createNotification('Open a topic to edit its system prompt', 'error');
+      return;
+    }
+    
+    fetch(`/topics/${currentChatUUID}`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({
+        title: currentTopic,
+        system_prompt: newPrompt
+      })
+    })
+    .then(response => response.json().then(data => {
+      if (!response.ok) {
+        throw new Error(data.error || 'Failed to update system prompt');
+      }
+      return data;
+    }))
+    .then(topic => {
+      showSystemPrompt(topic.system_prompt);
+      form.addClass('hidden');
+      display.removeClass('hidden');
+      createNotification('System prompt updated', 'success');
+    })
+    .catch(error => {
+      console.error('Error updating system prompt:', error);
+      createNotification(error.message, 'error');
+    });
+  }
+  
   // Send message on button click or enter key
   sendButton.on('click', sendMessage);
   messageInput.on('keydown', function(e) {
@@ -749,21 +769,28 @@ How can I assist you today?`;
       chat_uuid: currentChatUUID
     };
     
-    // Add file data if available
+    // Upload the file first and reference it from the chat request
     if (uploadedFile) {
-      const reader = new FileReader();
-      reader.onload = function(e) {
-        // Add file data to request
-        requestData.file = {
-          name: uploadedFile.name,
-          content: e.target.result.split(',')[1], // Get base64 data without the prefix
-          type: uploadedFile.type
-        };
-        
-        // Send to API with file
-        sendToApi(requestData);
-      };
-      reader.readAsDataURL(uploadedFile);
+      const formData = new FormData();
+      formData.append('files', uploadedFile);
+      
+      fetch(`/chat/${currentChatUUID}/files`, { method: 'POST', body: formData })
+        .then(response => response.json().then(data => {
+          if (!response.ok) {
+            throw new Error(data.error || 'Failed to upload file');
+          }
+          return data;
+        }))
+        .then(data => {
+          requestData.file_uuids = data.files.map(file => file.uuid);
+          
+          // Send to API with file
+          sendToApi(requestData);
+        })
+        .catch(error => {
+          removeTypingIndicator();
+          createNotification(error.message, 'error');
+        });
     } else {
       // Send to API without file
       sendToApi(requestData);
@@ -773,9 +800,6 @@ How can I assist you today?`;
   function sendToApi(requestData) {
     // console.log('Sending to API:', requestData);
     
-    // Add system prompt to the request
-    requestData.system = systemPrompt;
-    
     // If running in development mode, use simulated response
     if (window.location.hostname === 'localhost' && false) {
       simulateStreamResponse(requestData.message);
@@ -799,16 +823,31 @@ How can I assist you today?`;
     streamRequest(apiUrl, requestData, signal);
   }
   
+  /**
+   * Replays and follows the generation still running for a topic
+   * @param {string} topicUUID - The topic whose answer is being generated
+   */
+  function resumeStream(topicUUID) {
+    if (currentStreamController) {
+      currentStreamController.abort();
+    }
+    currentStreamController = new AbortController();
+    
+    addTypingIndicator();
+    streamRequest(`/chat/${topicUUID}/stream`, null, currentStreamController.signal)
+      .catch(error => console.error('Failed to resume stream:', error));
+  }
+  
   /**
    * Performs a streaming request to the server
    * @param {string} url - The URL to send the request to
-   * @param {object} data - The data to send in the request
+   * @param {object} data - The data to send in the request, null to attach to a running generation
    * @param {AbortSignal} signal - AbortController signal for cancellation
    */
   function streamRequest(url, data, signal) {
     return new Promise((resolve, reject) => {
       const xhr = new XMLHttpRequest();
-      xhr.open('POST', url);
+      xhr.open(data ? 'POST' : 'GET', url);
       xhr.setRequestHeader('Content-Type', 'application/json');
       xhr.setRequestHeader('Accept', 'text/event-stream');
       xhr.responseType = 'text';
@@ -824,6 +863,7 @@ How can I assist you today?`;
       
       let aiMessage = '';
       let isFirstChunk = true;
+      let processed = 0;
       
       xhr.onreadystatechange = function() {
         if (xhr.readyState === 3 || xhr.readyState === 4) {
@@ -835,6 +875,11 @@ How can I assist you today?`;
           if (xhr.status === 200) {
             // Request completed successfully
             resolve(aiMessage);
+          } else if (xhr.status === 409) {
+            // Another reply of this topic is still being generated
+            removeTypingIndicator();
+            createNotification('Please wait for the current reply to finish', 'warning');
+            reject(new Error('Reply already in progress'));
           } else {
             // Handle errors
             console.error('Stream request failed:', xhr.status, xhr.statusText);
@@ -844,60 +889,114 @@ How can I assist you today?`;
       };
       
       function readStream() {
-        let newData = xhr.responseText;
+        // Only complete frames are handled, a partial frame waits for the next chunk
+        const newData = xhr.responseText.substring(processed);
+        const end = newData.lastIndexOf('\n\n');
+        if (end === -1) return;
+        processed += end + 2;
         
-        // Split the response by event delimiter and process each event
-        const events = newData.split('data: ');
-        
-        for (let i = 0; i < events.length; i++) {
-          const event = events[i].trim();
-          if (!event) continue;
+        for (const frame of newData.substring(0, end).split('\n\n')) {
+          let eventName = 'message';
+          let eventData = '';
+          for (const line of frame.split('\n')) {
+            if (line.startsWith('event: ')) {
+              eventName = line.substring(7);
+            } else if (line.startsWith('data: ')) {
+              eventData += line.substring(6);
+            }
+          }
+          if (!eventData) continue;
           
           try {
-            const parsed = JSON.parse(event);
-            
-            // Check for DONE marker
-            if (parsed.content === '|DONE|') {
-              // Remove typing indicator if it exists
+            handleStreamEvent(eventName, JSON.parse(eventData));
+          } catch (e) {
+            console.error('Error parsing event stream:', e, frame);
+          }
+        }
+      }
+      
+      // Handles one event of the POST /chat stream, see pkg/stream for the protocol
+      function handleStreamEvent(eventName, payload) {
+        switch (eventName) {
+          case 'delta':
+            if (!payload.text) return;
+            if (isFirstChunk) {
+              // Remove typing indicator on first content
               removeTypingIndicator();
-              
-              // Complete message is done, add final message if not already added
-              if (aiMessage && isFirstChunk) {
-                addAiMessage(aiMessage, true);
-                isFirstChunk = false;
-              }
-              
-              continue;
+              addAiMessage(payload.text, false);
+              aiMessage = payload.text;
+              isFirstChunk = false;
+            } else {
+              aiMessage += payload.text;
+              updateAiMessage(aiMessage);
             }
-            
-            // Handle content chunks
-            if (parsed.content) {
-              // Remove typing indicator on first content
-              if (isFirstChunk) {
-                removeTypingIndicator();
-                
-                // Add initial message
-                addAiMessage(parsed.content, false);
-                aiMessage = parsed.content;
-                isFirstChunk = false;
-              } else {
-                // Append to existing message
-                aiMessage += parsed.content;
-                
-                // Update message in UI
-                updateAiMessage(aiMessage);
-              }
+            break;
+          case 'tool_call':
+            console.debug('Tool call', payload.phase, payload.name, payload);
+            break;
+          case 'approval_required':
+            // A resumed stream replays the job from its first event, including decided approvals
+            requestToolApproval(payload.tool_call, !data);
+            break;
+          case 'error':
+            createNotification(payload.message, 'error');
+            break;
+          case 'done':
+            removeTypingIndicator();
+            if (aiMessage) {
+              addAiMessage(aiMessage, true);
             }
-          } catch (e) {
-            console.error('Error parsing event stream:', e, event);
-          }
+            isFirstChunk = false;
+            break;
         }
       }
       
-      xhr.send(JSON.stringify(data));
+      xhr.send(data ? JSON.stringify(data) : null);
     });
   }
   
+  /**
+   * Asks the user to approve a mutating tool call and sends the decision, once per tool call
+   * @param {object} toolCall - The tool_calls row waiting for approval
+   * @param {boolean} replayed - Whether the request was replayed, it may have been decided since
+   */
+  function requestToolApproval(toolCall, replayed = false) {
+    if (promptedToolCalls.has(toolCall.uuid)) return;
+    promptedToolCalls.add(toolCall.uuid);
+    
+    if (!replayed) {
+      askToolApproval(toolCall);
+      return;
+    }
+    
+    fetch(`/tool-calls/${toolCall.uuid}`)
+      .then(response => response.ok ? response.json() : null)
+      .then(current => {
+        if (current && current.approval === 'required' && current.status === 'pending') {
+          askToolApproval(current);
+        }
+      })
+      .catch(error => console.error('Failed to get tool call:', error));
+  }
+  
+  /**
+   * Shows the approval prompt of a tool call and sends the decision
+   * @param {object} toolCall - The tool_calls row waiting for approval
+   */
+  function askToolApproval(toolCall) {
+    const details = toolCall.preview || toolCall.input;
+    const approved = window.confirm(`Allow the assistant to run ${toolCall.tool_name}?\n\n${details}`);
+    const action = approved ? 'approve' : 'deny';
+    
+    fetch(`/tool-calls/${toolCall.uuid}/${action}`, { method: 'POST' })
+      .then(response => {
+        if (!response.ok) {
+          createNotification(`Failed to ${action} ${toolCall.tool_name}`, 'error');
+        }
+      })
+      .catch(error => console.error('Failed to send tool approval:', error));
+  }
+  
   /**
    * For testing only: Simulates a streaming response
    * @param {string} userMessage - The user's message
@@ -982,6 +1081,7 @@ How can I assist you today?`;
       // Set as current topic and chat UUID
       currentTopic = topic.title;
       currentChatUUID = topic.uuid;
+      showSystemPrompt(topic.system_prompt);
       
       // Clear the chat and add welcome message
       messagesContainer.empty();
@@ -1081,6 +1181,8 @@ How can I assist you today?`;
         //   messageCount: data.messages?.length || 0
         // });
         
+        showSystemPrompt(data.chat && data.chat.system_prompt);
+        
         // Clear everything in the messages container
         messagesContainer.empty();
         
@@ -1110,6 +1212,12 @@ How can I assist you today?`;
               applyHighlighting();
               scrollToBottom();
               
+              // Re-attach to an answer that is still being generated on the server
+              const lastMessage = data.messages[data.messages.length - 1];
+              if (lastMessage && lastMessage.role === 'assistant' && lastMessage.status === 'pending') {
+                resumeStream(topicUUID);
+              }
+              
               // Final verification
               setTimeout(() => {
                 if (currentTopicLoadingId !== loadingId) return;
@@ -1137,7 +1245,10 @@ How can I assist you today?`;
               lastUserMessage = msg.content;
               processedCount++;
             } else if (msg.role === 'assistant') {
-              addAiMessage(msg.content, true);
+              // A pending answer is replayed from its stream instead
+              if (msg.status !== 'pending') {
+                addAiMessage(msg.content, true);
+              }
               processedCount++;
             } else {
               console.warn(`Unknown message role: ${msg.role}`);
@@ -1219,6 +1330,7 @@ How can I assist you today?`;
       currentTopic = "Getting started with Wisdomizer";
       currentChatUUID = null;
       lastUserMessage = "";
+      showSystemPrompt('');
     }
     
     // Remove the topic from the UI immediately